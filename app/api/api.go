@@ -4,51 +4,162 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/ghosts-network/news-feed/app/gql"
 	"github.com/ghosts-network/news-feed/infrastructure"
 	"github.com/ghosts-network/news-feed/migrator"
 	"github.com/ghosts-network/news-feed/news"
+	"github.com/ghosts-network/news-feed/utils/health"
 	"github.com/ghosts-network/news-feed/utils/logger"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
-	"github.com/pkg/errors"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"time"
 )
 
-func RunServer() {
+const apiVersionPrefix = "/v1"
+
+func RunServer(ctx context.Context, newsStorage *news.MongoNewsStorage, checks *health.Checks) {
 	log.SetFlags(0)
 
-	newsStorage := news.NewMongoNewsStorage(os.Getenv("MONGO_CONNECTION"))
+	limits := newLimitsFromEnv()
+	migrations := newSingleFlight()
 
 	r := mux.NewRouter()
+	r.Handle("/health", checks).Methods(http.MethodGet)
+	registerRoutes(r.PathPrefix(apiVersionPrefix).Subrouter(), newsStorage, migrations)
+
+	// routes which existed without version prefix are kept for existing clients
+	deprecated := r.NewRoute().Subrouter()
+	deprecated.Use(deprecationMiddleware)
+	registerLegacyRoutes(deprecated, newsStorage, migrations)
+
+	r.Use(scopedLoggerMiddleware)
+	r.Use(loggingMiddleware)
+	r.Use(compressionMiddleware)
+	r.Use(setJsonContentType)
+	r.Use(limits.loadSheddingMiddleware)
+	r.Use(limits.rateLimitMiddleware)
+
+	serve(ctx, newServerFromEnv(r))
+}
+
+func registerRoutes(r *mux.Router, newsStorage *news.MongoNewsStorage, migrations *singleFlight) {
+	registerLegacyRoutes(r, newsStorage, migrations)
+
+	sources := &sourcesHandler{newsStorage: newsStorage}
+	r.HandleFunc("/users/{user}/sources", sources.List).Methods(http.MethodGet)
+	r.HandleFunc("/users/{user}/sources", sources.Add).Methods(http.MethodPost)
+	r.HandleFunc("/users/{user}/sources", sources.Replace).Methods(http.MethodPut)
+	r.HandleFunc("/users/{user}/sources/{source}", sources.Remove).Methods(http.MethodDelete)
+
+	r.Handle("/admin/users/{user}/export", newExportHandlerFromEnv(newsStorage)).Methods(http.MethodGet)
+
+	r.HandleFunc("/admin/users/{user}/publications/{publication}/explain", func(w http.ResponseWriter, r *http.Request) {
+		user := mux.Vars(r)["user"]
+		publication := mux.Vars(r)["publication"]
+
+		explanation, err := newsStorage.ExplainNews(r.Context(), user, publication)
+		if err != nil {
+			writeError(w, r, err, fmt.Sprintf("Failed to explain publication %s for %s", publication, user))
+			return
+		}
+
+		body, err := json.Marshal(explanation)
+		if err != nil {
+			writeError(w, r, err, "Failed to marshal explanation")
+			return
+		}
+
+		_, _ = w.Write(body)
+	}).Methods(http.MethodGet)
+
+	r.Handle("/feeds/batch", newBatchNewsHandlerFromEnv(newsStorage)).Methods(http.MethodPost)
+
+	r.Handle("/graphql", gql.NewHandler(newsStorage, presentGraphqlError)).Methods(http.MethodPost)
+}
+
+// registerLegacyRoutes registers routes which were served before versioning
+func registerLegacyRoutes(r *mux.Router, newsStorage *news.MongoNewsStorage, migrations *singleFlight) {
 	r.HandleFunc("/{user}", func(w http.ResponseWriter, r *http.Request) {
 		user := mux.Vars(r)["user"]
 		cursor := r.URL.Query().Get("cursor")
+		before := r.URL.Query().Get("before")
 		take, _ := strconv.Atoi(r.URL.Query().Get("take"))
 		if 0 >= take || take > 100 {
 			take = 20
 		}
 
-		ps, err := newsStorage.FindNews(r.Context(), user, cursor, take)
+		contentType := negotiateFeedFormat(r)
+		w.Header().Add("Vary", "Accept")
+
+		// syndication formats are rendered from complete publications
+		var fields []string
+		if contentType == "" {
+			fields = requestedFields(r)
+		}
+
+		// malformed query is rejected before it could be answered with 304
+		pageCursor := cursor
+		if pageCursor == "" {
+			pageCursor = before
+		}
+		if err := news.ValidateFeedQuery(pageCursor, fields...); err != nil {
+			writeError(w, r, err, "Failed to fetch news")
+			return
+		}
+
+		version, err := newsStorage.FindFeedVersion(r.Context(), user)
+		if err != nil {
+			writeError(w, r, err, "Failed to fetch feed version")
+			return
+		}
+
+		etag, lastModified := feedValidators(version, r, contentType)
+		setCacheHeaders(w, etag, lastModified)
+		if notModified(r, etag, lastModified) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		var ps []news.Publication
+		if before != "" && cursor == "" {
+			ps, err = newsStorage.FindNewsBefore(r.Context(), user, before, take, fields...)
+		} else {
+			ps, err = newsStorage.FindNews(r.Context(), user, cursor, take, fields...)
+		}
+		if err != nil {
+			writeError(w, r, err, "Failed to fetch news")
+			return
+		}
+
+		page := newFeedPage(r, user, take, ps)
+		setLinkHeader(w, page)
+
+		if contentType != "" {
+			if err := writeFeed(w, contentType, page); err != nil {
+				writeError(w, r, err, "Failed to render news feed")
+			}
+			return
+		}
+
+		items, err := sparsePublications(ps, fields)
 		if err != nil {
-			logger.Error(errors.Wrap(err, fmt.Sprintf("Failed to fetch news")), &map[string]any{
-				"correlationId": r.Context().Value("correlationId"),
-			})
-			w.WriteHeader(http.StatusInternalServerError)
-			_, _ = w.Write([]byte(err.Error()))
+			writeError(w, r, err, "Failed to select publication fields")
 			return
 		}
 
-		body, err := json.Marshal(ps)
+		var body []byte
+		if wantsEnvelope(r) {
+			body, err = json.Marshal(newFeedEnvelope(page, items))
+		} else {
+			body, err = json.Marshal(items)
+		}
 		if err != nil {
-			logger.Error(errors.Wrap(err, fmt.Sprintf("Failed to marshal news")), &map[string]any{
-				"correlationId": r.Context().Value("correlationId"),
-			})
-			w.WriteHeader(http.StatusInternalServerError)
-			_, _ = w.Write([]byte(err.Error()))
+			writeError(w, r, err, "Failed to marshal news")
 			return
 		}
 
@@ -59,35 +170,32 @@ func RunServer() {
 		_, _ = w.Write(body)
 	}).Methods(http.MethodGet)
 
-	r.HandleFunc("/migrator/users", func(w http.ResponseWriter, r *http.Request) {
-		getMigrator().
+	r.HandleFunc("/migrator/users", migrations.guard(func(w http.ResponseWriter, r *http.Request) {
+		getMigrator(newsStorage).
 			MigrateUsers(r.Context())
 
 		w.WriteHeader(http.StatusOK)
-	}).Methods(http.MethodPost)
+	})).Methods(http.MethodPost)
 
-	r.HandleFunc("/migrator/users/{user}", func(w http.ResponseWriter, r *http.Request) {
+	r.HandleFunc("/migrator/users/{user}", migrations.guard(func(w http.ResponseWriter, r *http.Request) {
 		user := mux.Vars(r)["user"]
 
-		getMigrator().
+		err := getMigrator(newsStorage).
 			MigrateUser(r.Context(), user)
+		if err != nil {
+			writeError(w, r, err, fmt.Sprintf("Failed to migrate user %s", user))
+			return
+		}
 
 		w.WriteHeader(http.StatusOK)
-	}).Methods(http.MethodPost)
+	})).Methods(http.MethodPost)
 
-	r.HandleFunc("/migrator/publications", func(w http.ResponseWriter, r *http.Request) {
-		getMigrator().
+	r.HandleFunc("/migrator/publications", migrations.guard(func(w http.ResponseWriter, r *http.Request) {
+		getMigrator(newsStorage).
 			MigratePublications(r.Context())
 
 		w.WriteHeader(http.StatusOK)
-	}).Methods(http.MethodPost)
-
-	r.Use(scopedLoggerMiddleware)
-	r.Use(loggingMiddleware)
-	r.Use(setJsonContentType)
-
-	logger.Info("Starting http server on port 80", &map[string]any{})
-	logger.Error(http.ListenAndServe(":80", r), &map[string]any{})
+	})).Methods(http.MethodPost)
 }
 
 func scopedLoggerMiddleware(next http.Handler) http.Handler {
@@ -126,6 +234,15 @@ func loggingMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+func deprecationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		successor := url.URL{Path: apiVersionPrefix + r.URL.Path, RawQuery: r.URL.RawQuery}
+		w.Header().Set("Deprecation", "true")
+		w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, successor.String()))
+		next.ServeHTTP(w, r)
+	})
+}
+
 func setJsonContentType(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
@@ -150,13 +267,18 @@ func (w *StatusWriter) WriteHeader(status int) {
 	w.ResponseWriter.WriteHeader(status)
 }
 
-func getMigrator() *migrator.Migrator {
+func (w *StatusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func getMigrator(newsStorage *news.MongoNewsStorage) *migrator.Migrator {
 	httpClient := infrastructure.NewScopedClient()
 
 	profileClient := infrastructure.NewProfilesClient(os.Getenv("PROFILES_ADDRESS"), httpClient)
 	relationsClient := infrastructure.NewRelationsClient(os.Getenv("PROFILES_ADDRESS"), httpClient)
 	publicationsClient := infrastructure.NewPublicationsClient(os.Getenv("CONTENT_ADDRESS"), httpClient)
-	newsStorage := news.NewMongoNewsStorage(os.Getenv("MONGO_CONNECTION"))
 
 	return migrator.NewMigrator(profileClient, relationsClient, publicationsClient, newsStorage)
 }
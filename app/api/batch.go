@@ -0,0 +1,96 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ghosts-network/news-feed/news"
+	"github.com/ghosts-network/news-feed/utils/env"
+	"net/http"
+)
+
+type batchNewsRequest struct {
+	Users []string `json:"users"`
+	Take  int      `json:"take"`
+}
+
+type batchNewsItem struct {
+	User         string             `json:"user"`
+	Publications []news.Publication `json:"publications"`
+	Cursor       string             `json:"cursor,omitempty"`
+}
+
+type batchNewsHandler struct {
+	newsStorage *news.MongoNewsStorage
+	maxUsers    int
+	maxTake     int
+	concurrency int
+}
+
+func newBatchNewsHandlerFromEnv(newsStorage *news.MongoNewsStorage) *batchNewsHandler {
+	// feeds are fetched one by one at least
+	concurrency := env.Int("BATCH_CONCURRENCY", 10)
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	return &batchNewsHandler{
+		newsStorage: newsStorage,
+		maxUsers:    env.Int("BATCH_MAX_USERS", 1000),
+		maxTake:     env.Int("BATCH_MAX_TAKE", 20),
+		concurrency: concurrency,
+	}
+}
+
+func (h *batchNewsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req batchNewsRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		writeProblem(w, NewProblem(r, BadRequest, "Request body is not valid json"))
+		return
+	}
+
+	if len(req.Users) == 0 || len(req.Users) > h.maxUsers {
+		writeProblem(w, NewProblem(r, BadRequest, fmt.Sprintf("Number of users should be between 1 and %d", h.maxUsers)))
+		return
+	}
+	if 0 >= req.Take {
+		req.Take = 5
+	}
+	if req.Take > h.maxTake {
+		req.Take = h.maxTake
+	}
+
+	users := make([]string, 0, len(req.Users))
+	seen := make(map[string]bool, len(req.Users))
+	for _, user := range req.Users {
+		if user != "" && !seen[user] {
+			seen[user] = true
+			users = append(users, user)
+		}
+	}
+
+	feeds, err := h.newsStorage.FindNewsBatch(r.Context(), users, req.Take, h.concurrency)
+	if err != nil {
+		writeError(w, r, err, "Failed to fetch news batch")
+		return
+	}
+
+	items := make([]batchNewsItem, 0, len(users))
+	for _, user := range users {
+		item := batchNewsItem{User: user, Publications: feeds[user]}
+		if item.Publications == nil {
+			item.Publications = make([]news.Publication, 0)
+		}
+		if len(item.Publications) == req.Take {
+			item.Cursor = item.Publications[len(item.Publications)-1].Id
+		}
+		items = append(items, item)
+	}
+
+	body, err := json.Marshal(items)
+	if err != nil {
+		writeError(w, r, err, "Failed to marshal news batch")
+		return
+	}
+
+	_, _ = w.Write(body)
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"github.com/ghosts-network/news-feed/news"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// feedValidators builds cache validators of the feed page from version of the user's feed,
+// every write to the feed changes validators of every page
+func feedValidators(version news.FeedVersion, r *http.Request, contentType string) (string, time.Time) {
+	state := strconv.FormatInt(version.Version, 10)
+	var lastModified time.Time
+	if version.Version > 0 {
+		lastModified = version.UpdatedOn
+	}
+
+	h := sha1.New()
+	_, _ = fmt.Fprintf(h, "%s|%s|%s|%s", r.URL.Path, r.URL.RawQuery, contentType, state)
+
+	return fmt.Sprintf(`W/"%s"`, hex.EncodeToString(h.Sum(nil))[:20]), lastModified
+}
+
+func setCacheHeaders(w http.ResponseWriter, etag string, lastModified time.Time) {
+	w.Header().Set("ETag", etag)
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+	w.Header().Set("Cache-Control", "private, no-cache")
+}
+
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		t, err := http.ParseTime(ims)
+		if err != nil {
+			return false
+		}
+
+		return !lastModified.Truncate(time.Second).After(t)
+	}
+
+	return false
+}
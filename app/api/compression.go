@@ -0,0 +1,122 @@
+package api
+
+import (
+	"compress/gzip"
+	"github.com/andybalholm/brotli"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	brotliEncoding = "br"
+	gzipEncoding   = "gzip"
+)
+
+// negotiateEncoding picks compression from Accept-Encoding, brotli wins over gzip with the same weight
+func negotiateEncoding(r *http.Request) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+
+		q := 1.0
+		if params = strings.TrimSpace(params); strings.HasPrefix(params, "q=") {
+			var err error
+			if q, err = strconv.ParseFloat(strings.TrimPrefix(params, "q="), 64); err != nil {
+				continue
+			}
+		}
+
+		switch coding {
+		case brotliEncoding, gzipEncoding, "*":
+			if coding == "*" {
+				coding = brotliEncoding
+			}
+			if q > bestQ || q == bestQ && q > 0 && coding == brotliEncoding {
+				best, bestQ = coding, q
+			}
+		}
+	}
+
+	return best
+}
+
+func compressionMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding := negotiateEncoding(r)
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressedWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.Close()
+
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// compressedWriter decides whether to compress on the first write, so responses without body
+// or already encoded by handler are passed through untouched
+type compressedWriter struct {
+	http.ResponseWriter
+	encoding    string
+	writer      io.WriteCloser
+	wroteHeader bool
+}
+
+func (w *compressedWriter) WriteHeader(statusCode int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	h := w.Header()
+	if bodyAllowed(statusCode) && h.Get("Content-Encoding") == "" && h.Get("Content-Type") != zipContentType {
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+
+		switch w.encoding {
+		case brotliEncoding:
+			w.writer = brotli.NewWriterLevel(w.ResponseWriter, brotli.DefaultCompression)
+		case gzipEncoding:
+			w.writer = gzip.NewWriter(w.ResponseWriter)
+		}
+	}
+
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *compressedWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.writer == nil {
+		return w.ResponseWriter.Write(b)
+	}
+
+	return w.writer.Write(b)
+}
+
+func (w *compressedWriter) Flush() {
+	if f, ok := w.writer.(interface{ Flush() error }); ok {
+		_ = f.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *compressedWriter) Close() {
+	if w.writer != nil {
+		_ = w.writer.Close()
+	}
+}
+
+func bodyAllowed(statusCode int) bool {
+	return statusCode >= 200 && statusCode != http.StatusNoContent && statusCode != http.StatusNotModified
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"github.com/ghosts-network/news-feed/news"
+	"github.com/ghosts-network/news-feed/utils/env"
+	"github.com/ghosts-network/news-feed/utils/logger"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	ndjsonContentType = "application/x-ndjson"
+	zipContentType    = "application/zip"
+)
+
+// exportHandler streams personal data of the user as ndjson or zip archive with ndjson file per record type
+type exportHandler struct {
+	newsStorage *news.MongoNewsStorage
+	pageSize    int
+}
+
+func newExportHandlerFromEnv(newsStorage *news.MongoNewsStorage) *exportHandler {
+	pageSize := env.Int("EXPORT_PAGE_SIZE", 500)
+	if pageSize <= 0 {
+		pageSize = 500
+	}
+
+	return &exportHandler{
+		newsStorage: newsStorage,
+		pageSize:    pageSize,
+	}
+}
+
+func (h *exportHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user := mux.Vars(r)["user"]
+
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "", "ndjson":
+		w.Header().Set("Content-Type", ndjsonContentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.ndjson"`, user))
+	case "zip":
+		w.Header().Set("Content-Type", zipContentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, user))
+	default:
+		writeProblem(w, NewProblem(r, BadRequest, "Format should be ndjson or zip"))
+		return
+	}
+
+	var archive *zip.Writer
+	var out io.Writer = w
+	var current news.ExportRecordType
+	started := false
+
+	err := h.newsStorage.ExportUserData(r.Context(), user, h.pageSize, func(record news.ExportRecord) error {
+		started = true
+
+		if format == "zip" {
+			if archive == nil {
+				archive = zip.NewWriter(w)
+			}
+			if record.Type != current {
+				entry, err := archive.CreateHeader(&zip.FileHeader{
+					Name:     fmt.Sprintf("%ss.ndjson", record.Type),
+					Method:   zip.Deflate,
+					Modified: time.Now(),
+				})
+				if err != nil {
+					return err
+				}
+				out, current = entry, record.Type
+			}
+		}
+
+		line, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(append(line, '\n'))
+		return err
+	})
+
+	if err != nil && !started {
+		writeError(w, r, err, fmt.Sprintf("Failed to export data of %s", user))
+		return
+	}
+	if err != nil {
+		// response is already partially sent, so client can only detect failure by broken stream
+		logger.Error(errors.Wrap(err, fmt.Sprintf("Export of %s interrupted", user)), &map[string]any{
+			"correlationId": r.Context().Value("correlationId"),
+		})
+		return
+	}
+
+	if format == "zip" {
+		if archive == nil {
+			archive = zip.NewWriter(w)
+		}
+		if err := archive.Close(); err != nil {
+			logger.Error(errors.Wrap(err, fmt.Sprintf("Failed to finish export archive of %s", user)), &map[string]any{
+				"correlationId": r.Context().Value("correlationId"),
+			})
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/ghosts-network/news-feed/news"
+	"net/http"
+	"strings"
+)
+
+// requestedFields returns publication fields listed in fields query parameter, id is always included
+func requestedFields(r *http.Request) []string {
+	raw := r.URL.Query().Get("fields")
+	if raw == "" {
+		return nil
+	}
+
+	fields := []string{"id"}
+	seen := map[string]bool{"id": true}
+	for _, f := range strings.Split(raw, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" || seen[f] {
+			continue
+		}
+		seen[f] = true
+		fields = append(fields, f)
+	}
+
+	return fields
+}
+
+// sparsePublications drops publication fields which were not requested
+func sparsePublications(ps []news.Publication, fields []string) (any, error) {
+	if len(fields) == 0 {
+		return ps, nil
+	}
+
+	result := make([]map[string]json.RawMessage, 0, len(ps))
+	for i := range ps {
+		body, err := json.Marshal(&ps[i])
+		if err != nil {
+			return nil, err
+		}
+
+		var all map[string]json.RawMessage
+		if err := json.Unmarshal(body, &all); err != nil {
+			return nil, err
+		}
+
+		sparse := make(map[string]json.RawMessage, len(fields))
+		for _, f := range fields {
+			if v, ok := all[f]; ok {
+				sparse[f] = v
+			}
+		}
+		result = append(result, sparse)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,189 @@
+package api
+
+import (
+	"fmt"
+	"github.com/ghosts-network/news-feed/utils/env"
+	"github.com/gorilla/mux"
+	"golang.org/x/time/rate"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const limiterIdleTimeout = 10 * time.Minute
+
+type keyedLimiter struct {
+	limit rate.Limit
+	burst int
+
+	mu        sync.Mutex
+	limiters  map[string]*limiterEntry
+	lastSweep time.Time
+}
+
+type limiterEntry struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// newKeyedLimiter returns nil if rate limiting is disabled
+func newKeyedLimiter(rps float64, burst int) *keyedLimiter {
+	if rps <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = int(math.Ceil(rps))
+	}
+
+	return &keyedLimiter{
+		limit:     rate.Limit(rps),
+		burst:     burst,
+		limiters:  make(map[string]*limiterEntry),
+		lastSweep: time.Now(),
+	}
+}
+
+// reserve takes a token for the key and returns how long client should wait before retry if there was no token
+func (l *keyedLimiter) reserve(key string) time.Duration {
+	now := time.Now()
+
+	l.mu.Lock()
+	if now.Sub(l.lastSweep) > limiterIdleTimeout {
+		for k, e := range l.limiters {
+			if now.Sub(e.lastSeen) > limiterIdleTimeout {
+				delete(l.limiters, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	e, ok := l.limiters[key]
+	if !ok {
+		e = &limiterEntry{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.limiters[key] = e
+	}
+	e.lastSeen = now
+	l.mu.Unlock()
+
+	r := e.limiter.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		r.CancelAt(now)
+		return delay
+	}
+
+	return 0
+}
+
+type limits struct {
+	callers     *keyedLimiter
+	users       *keyedLimiter
+	concurrency chan struct{}
+}
+
+func newLimitsFromEnv() *limits {
+	l := &limits{
+		callers: newKeyedLimiter(env.Float("RATE_LIMIT_CALLER_RPS", 0), env.Int("RATE_LIMIT_CALLER_BURST", 0)),
+		users:   newKeyedLimiter(env.Float("RATE_LIMIT_USER_RPS", 0), env.Int("RATE_LIMIT_USER_BURST", 0)),
+	}
+	if n := env.Int("MAX_CONCURRENT_REQUESTS", 0); n > 0 {
+		l.concurrency = make(chan struct{}, n)
+	}
+
+	return l
+}
+
+// loadSheddingMiddleware rejects requests above global concurrency limit instead of queueing them
+func (l *limits) loadSheddingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.concurrency == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		select {
+		case l.concurrency <- struct{}{}:
+			defer func() { <-l.concurrency }()
+			next.ServeHTTP(w, r)
+		default:
+			w.Header().Set("Retry-After", "1")
+			writeProblem(w, NewProblem(r, Overloaded, "Server is handling too many requests"))
+		}
+	})
+}
+
+func (l *limits) rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.callers != nil {
+			if delay := l.callers.reserve(callerKey(r)); delay > 0 {
+				rejectRateLimited(w, r, delay, "Rate limit of the caller exceeded")
+				return
+			}
+		}
+
+		if user, ok := mux.Vars(r)["user"]; ok && l.users != nil {
+			if delay := l.users.reserve(user); delay > 0 {
+				rejectRateLimited(w, r, delay, fmt.Sprintf("Rate limit for user %s exceeded", user))
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func callerKey(r *http.Request) string {
+	if caller := r.Header.Get("X-Caller-ID"); caller != "" {
+		return caller
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+func rejectRateLimited(w http.ResponseWriter, r *http.Request, delay time.Duration, detail string) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+	writeProblem(w, NewProblem(r, RateLimited, detail))
+}
+
+// singleFlight allows only one of guarded requests to run at a time, migrations of users and publications
+// rewrite the same collections. Concurrent requests are rejected rather than queued
+type singleFlight struct {
+	mu      sync.Mutex
+	running string
+}
+
+func newSingleFlight() *singleFlight {
+	return &singleFlight{}
+}
+
+func (s *singleFlight) guard(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, apiVersionPrefix)
+
+		s.mu.Lock()
+		if s.running != "" {
+			running := s.running
+			s.mu.Unlock()
+			writeProblem(w, NewProblem(r, OperationInProgress, fmt.Sprintf("%s is already in progress", running)))
+			return
+		}
+		s.running = key
+		s.mu.Unlock()
+
+		defer func() {
+			s.mu.Lock()
+			s.running = ""
+			s.mu.Unlock()
+		}()
+
+		next(w, r)
+	}
+}
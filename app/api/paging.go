@@ -0,0 +1,130 @@
+package api
+
+import (
+	"fmt"
+	"github.com/ghosts-network/news-feed/news"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+type feedPage struct {
+	User         string
+	Publications []news.Publication
+	Take         int
+	SelfUrl      string
+	FirstUrl     string
+	PrevUrl      string
+	NextUrl      string
+}
+
+type feedEnvelope struct {
+	Items  any        `json:"items"`
+	Paging feedPaging `json:"paging"`
+}
+
+type feedPaging struct {
+	Take     int    `json:"take"`
+	Cursor   string `json:"cursor,omitempty"`
+	First    string `json:"first"`
+	Previous string `json:"previous,omitempty"`
+	Next     string `json:"next,omitempty"`
+}
+
+func newFeedPage(r *http.Request, user string, take int, ps []news.Publication) *feedPage {
+	base := url.URL{
+		Scheme: "http",
+		Host:   r.Host,
+		Path:   r.URL.Path,
+	}
+	if r.TLS != nil {
+		base.Scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		base.Scheme = proto
+	}
+
+	pageUrl := func(key string, value string) string {
+		u := base
+		q := url.Values{}
+		for _, preserved := range []string{"format", "envelope", "fields"} {
+			if v := r.URL.Query().Get(preserved); v != "" {
+				q.Set(preserved, v)
+			}
+		}
+		if value != "" {
+			q.Set(key, value)
+		}
+		q.Set("take", strconv.Itoa(take))
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+
+	cursor := r.URL.Query().Get("cursor")
+	before := r.URL.Query().Get("before")
+
+	page := &feedPage{
+		User:         user,
+		Publications: ps,
+		Take:         take,
+		FirstUrl:     pageUrl("", ""),
+	}
+
+	switch {
+	case cursor != "":
+		page.SelfUrl = pageUrl("cursor", cursor)
+	case before != "":
+		page.SelfUrl = pageUrl("before", before)
+	default:
+		page.SelfUrl = page.FirstUrl
+	}
+
+	// older items always follow page fetched backward, even when it is short
+	backward := before != "" && cursor == ""
+	if len(ps) == take || backward && len(ps) > 0 {
+		page.NextUrl = pageUrl("cursor", ps[len(ps)-1].Id)
+	}
+
+	// page fetched backward with less items than requested is the first one
+	if len(ps) > 0 && (cursor != "" || before != "" && len(ps) == take) {
+		page.PrevUrl = pageUrl("before", ps[0].Id)
+	}
+
+	return page
+}
+
+// setLinkHeader exposes paging as RFC 8288 web links
+func setLinkHeader(w http.ResponseWriter, page *feedPage) {
+	links := []string{fmt.Sprintf(`<%s>; rel="first"`, page.FirstUrl)}
+	if page.PrevUrl != "" {
+		links = append(links, fmt.Sprintf(`<%s>; rel="prev"`, page.PrevUrl))
+	}
+	if page.NextUrl != "" {
+		links = append(links, fmt.Sprintf(`<%s>; rel="next"`, page.NextUrl))
+	}
+
+	w.Header().Add("Link", strings.Join(links, ", "))
+}
+
+func wantsEnvelope(r *http.Request) bool {
+	v, _ := strconv.ParseBool(r.URL.Query().Get("envelope"))
+	return v
+}
+
+func newFeedEnvelope(page *feedPage, items any) *feedEnvelope {
+	envelope := &feedEnvelope{
+		Items: items,
+		Paging: feedPaging{
+			Take:     page.Take,
+			First:    page.FirstUrl,
+			Previous: page.PrevUrl,
+			Next:     page.NextUrl,
+		},
+	}
+	if len(page.Publications) > 0 {
+		envelope.Paging.Cursor = page.Publications[len(page.Publications)-1].Id
+	}
+
+	return envelope
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/ghosts-network/news-feed/infrastructure"
+	"github.com/ghosts-network/news-feed/news"
+	"github.com/ghosts-network/news-feed/utils/logger"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+	"net"
+	"net/http"
+)
+
+const problemContentType = "application/problem+json"
+
+type ProblemCode string
+
+const (
+	BadRequest          ProblemCode = "bad-request"
+	BadCursor           ProblemCode = "bad-cursor"
+	UnknownUser         ProblemCode = "unknown-user"
+	NotFound            ProblemCode = "not-found"
+	UpstreamUnavailable ProblemCode = "upstream-unavailable"
+	Timeout             ProblemCode = "timeout"
+	RateLimited         ProblemCode = "rate-limited"
+	Overloaded          ProblemCode = "overloaded"
+	OperationInProgress ProblemCode = "operation-in-progress"
+	SourceExists        ProblemCode = "source-exists"
+	InternalError       ProblemCode = "internal-error"
+)
+
+var problemDefinitions = map[ProblemCode]struct {
+	title  string
+	status int
+}{
+	BadRequest:          {"Request is malformed", http.StatusBadRequest},
+	BadCursor:           {"Cursor is not valid", http.StatusBadRequest},
+	UnknownUser:         {"User is unknown", http.StatusNotFound},
+	NotFound:            {"Resource not found", http.StatusNotFound},
+	UpstreamUnavailable: {"Upstream service is unavailable", http.StatusServiceUnavailable},
+	Timeout:             {"Request timed out", http.StatusGatewayTimeout},
+	RateLimited:         {"Too many requests", http.StatusTooManyRequests},
+	Overloaded:          {"Server is overloaded", http.StatusServiceUnavailable},
+	OperationInProgress: {"Operation is already in progress", http.StatusConflict},
+	SourceExists:        {"Source already exists", http.StatusConflict},
+	InternalError:       {"Internal server error", http.StatusInternalServerError},
+}
+
+// Problem is an error response body as described by RFC 7807
+type Problem struct {
+	Type          string      `json:"type"`
+	Title         string      `json:"title"`
+	Status        int         `json:"status"`
+	Detail        string      `json:"detail,omitempty"`
+	Instance      string      `json:"instance,omitempty"`
+	Code          ProblemCode `json:"code"`
+	CorrelationId string      `json:"correlationId"`
+}
+
+func NewProblem(r *http.Request, code ProblemCode, detail string) *Problem {
+	definition := problemDefinitions[code]
+	correlationId, _ := r.Context().Value("correlationId").(string)
+
+	return &Problem{
+		Type:          "urn:ghostnetwork:newsfeed:problem:" + string(code),
+		Title:         definition.title,
+		Status:        definition.status,
+		Detail:        detail,
+		Instance:      r.URL.Path,
+		Code:          code,
+		CorrelationId: correlationId,
+	}
+}
+
+func writeProblem(w http.ResponseWriter, problem *Problem) {
+	body, _ := json.Marshal(problem)
+
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(problem.Status)
+	_, _ = w.Write(body)
+}
+
+// writeError maps storage and infrastructure error onto problem response,
+// details of unexpected errors are only logged to avoid leaking internals
+func writeError(w http.ResponseWriter, r *http.Request, err error, message string) {
+	code := problemCode(err)
+
+	problem := NewProblem(r, code, "")
+	if detail, ok := problemDetail(code, err); ok {
+		problem.Detail = detail
+	} else {
+		logger.Error(errors.Wrap(err, message), &map[string]any{
+			"correlationId": r.Context().Value("correlationId"),
+		})
+	}
+
+	writeProblem(w, problem)
+}
+
+// presentGraphqlError maps error of graphql resolver the same way writeError does
+func presentGraphqlError(ctx context.Context, err error) (string, string) {
+	code := problemCode(err)
+	if detail, ok := problemDetail(code, err); ok {
+		return string(code), detail
+	}
+
+	logger.Error(errors.Wrap(err, "GraphQL resolver failed"), &map[string]any{
+		"correlationId": ctx.Value("correlationId"),
+	})
+
+	return string(code), problemDefinitions[code].title
+}
+
+// problemDetail returns message of the error when it is caused by the client and can be shown,
+// message keeps context added by wrapping, e.g. name of unknown field
+func problemDetail(code ProblemCode, err error) (string, bool) {
+	switch code {
+	case BadCursor, UnknownUser, NotFound, BadRequest:
+		return err.Error(), true
+	default:
+		return "", false
+	}
+}
+
+func problemCode(err error) ProblemCode {
+	var netErr net.Error
+	var upstreamErr *infrastructure.UpstreamError
+	var selectionErr topology.ServerSelectionError
+
+	switch {
+	case errors.Is(err, news.ErrInvalidCursor):
+		return BadCursor
+	case errors.Is(err, news.ErrUnknownField):
+		return BadRequest
+	case errors.Is(err, news.ErrUnknownUser):
+		return UnknownUser
+	case errors.Is(err, news.ErrPublicationNotFound), errors.Is(err, infrastructure.ErrNotFound):
+		return NotFound
+	case errors.Is(err, context.DeadlineExceeded), mongo.IsTimeout(err):
+		return Timeout
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return Timeout
+	case errors.As(err, &netErr), errors.As(err, &upstreamErr), errors.As(err, &selectionErr), mongo.IsNetworkError(err):
+		return UpstreamUnavailable
+	default:
+		return InternalError
+	}
+}
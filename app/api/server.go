@@ -0,0 +1,73 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/ghosts-network/news-feed/utils/env"
+	"github.com/ghosts-network/news-feed/utils/logger"
+	"github.com/pkg/errors"
+	"net/http"
+	"os"
+	"time"
+)
+
+type server struct {
+	*http.Server
+	certFile        string
+	keyFile         string
+	shutdownTimeout time.Duration
+}
+
+func newServerFromEnv(handler http.Handler) *server {
+	return &server{
+		Server: &http.Server{
+			Addr:              env.String("HTTP_ADDRESS", ":80"),
+			Handler:           handler,
+			ReadHeaderTimeout: env.Duration("HTTP_READ_HEADER_TIMEOUT", 10*time.Second),
+			ReadTimeout:       env.Duration("HTTP_READ_TIMEOUT", 30*time.Second),
+			WriteTimeout:      env.Duration("HTTP_WRITE_TIMEOUT", 60*time.Second),
+			IdleTimeout:       env.Duration("HTTP_IDLE_TIMEOUT", 120*time.Second),
+		},
+		certFile:        os.Getenv("HTTP_TLS_CERT_FILE"),
+		keyFile:         os.Getenv("HTTP_TLS_KEY_FILE"),
+		shutdownTimeout: env.Duration("HTTP_SHUTDOWN_TIMEOUT", 20*time.Second),
+	}
+}
+
+// serve blocks until ctx is cancelled and in-flight requests are drained
+func serve(ctx context.Context, srv *server) {
+	tls := srv.certFile != "" && srv.keyFile != ""
+
+	errc := make(chan error, 1)
+	go func() {
+		logger.Info(fmt.Sprintf("Starting http server on %s (tls: %t)", srv.Addr, tls), &map[string]any{})
+
+		var err error
+		if tls {
+			err = srv.ListenAndServeTLS(srv.certFile, srv.keyFile)
+		} else {
+			err = srv.ListenAndServe()
+		}
+		errc <- err
+	}()
+
+	select {
+	case err := <-errc:
+		logger.Error(errors.Wrap(err, "Http server stopped"), &map[string]any{})
+		return
+	case <-ctx.Done():
+	}
+
+	logger.Info("Shutting down http server", &map[string]any{})
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), srv.shutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Error(errors.Wrap(err, "Http server did not drain in-flight requests in time"), &map[string]any{})
+		_ = srv.Close()
+		return
+	}
+
+	logger.Info("Http server stopped", &map[string]any{})
+}
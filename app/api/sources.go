@@ -0,0 +1,126 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ghosts-network/news-feed/news"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+	"net/http"
+	"strconv"
+)
+
+type sourcesHandler struct {
+	newsStorage *news.MongoNewsStorage
+}
+
+func (h *sourcesHandler) List(w http.ResponseWriter, r *http.Request) {
+	user := mux.Vars(r)["user"]
+	skip, _ := strconv.Atoi(r.URL.Query().Get("skip"))
+	if skip < 0 {
+		skip = 0
+	}
+	take, _ := strconv.Atoi(r.URL.Query().Get("take"))
+	if 0 >= take || take > 100 {
+		take = 20
+	}
+
+	sources, err := h.newsStorage.FindUserSources(r.Context(), user, skip, take)
+	if err != nil {
+		writeError(w, r, err, fmt.Sprintf("Failed to fetch sources for %s", user))
+		return
+	}
+
+	body, err := json.Marshal(sources)
+	if err != nil {
+		writeError(w, r, err, "Failed to marshal sources")
+		return
+	}
+
+	_, _ = w.Write(body)
+}
+
+func (h *sourcesHandler) Add(w http.ResponseWriter, r *http.Request) {
+	user := mux.Vars(r)["user"]
+
+	source, ok := decodeSource(w, r)
+	if !ok {
+		return
+	}
+
+	// unique index rejects source which already exists, even when it is added concurrently
+	err := h.newsStorage.AddUserSource(r.Context(), user, source.Id, source.Kind)
+	if errors.Is(err, news.ErrSourceExists) {
+		writeProblem(w, NewProblem(r, SourceExists, fmt.Sprintf("%s is already a source of %s", source.Id, user)))
+		return
+	}
+	if err != nil {
+		writeError(w, r, err, fmt.Sprintf("Failed to add source %s for %s", source.Id, user))
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *sourcesHandler) Remove(w http.ResponseWriter, r *http.Request) {
+	user := mux.Vars(r)["user"]
+	source := mux.Vars(r)["source"]
+
+	if err := h.newsStorage.RemoveUserSource(r.Context(), user, source); err != nil {
+		writeError(w, r, err, fmt.Sprintf("Failed to remove source %s for %s", source, user))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *sourcesHandler) Replace(w http.ResponseWriter, r *http.Request) {
+	user := mux.Vars(r)["user"]
+
+	var sources []news.Source
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&sources); err != nil {
+		writeProblem(w, NewProblem(r, BadRequest, "Request body should be a json array of sources"))
+		return
+	}
+	for i := range sources {
+		if !validateSource(w, r, &sources[i]) {
+			return
+		}
+	}
+
+	if err := h.newsStorage.ReplaceUserSources(r.Context(), user, sources); err != nil {
+		writeError(w, r, err, fmt.Sprintf("Failed to replace sources for %s", user))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func decodeSource(w http.ResponseWriter, r *http.Request) (*news.Source, bool) {
+	var source news.Source
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&source); err != nil {
+		writeProblem(w, NewProblem(r, BadRequest, "Request body should be a json source"))
+		return nil, false
+	}
+
+	return &source, validateSource(w, r, &source)
+}
+
+// validateSource checks source and defaults its kind to friend
+func validateSource(w http.ResponseWriter, r *http.Request, source *news.Source) bool {
+	if source.Id == "" {
+		writeProblem(w, NewProblem(r, BadRequest, "Source id is required"))
+		return false
+	}
+
+	switch source.Kind {
+	case "":
+		source.Kind = news.FriendSource
+	case news.FriendSource, news.PendingRequestSource:
+	default:
+		writeProblem(w, NewProblem(r, BadRequest, fmt.Sprintf("Unknown source kind %s", source.Kind)))
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,386 @@
+package api
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"github.com/ghosts-network/news-feed/news"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	rssContentType      = "application/rss+xml"
+	atomContentType     = "application/atom+xml"
+	jsonFeedContentType = "application/feed+json"
+)
+
+var feedFormats = map[string]string{
+	"rss":      rssContentType,
+	"atom":     atomContentType,
+	"jsonfeed": jsonFeedContentType,
+}
+
+// negotiateFeedFormat returns syndication content type requested by client
+// or empty string when plain json response expected
+func negotiateFeedFormat(r *http.Request) string {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return feedFormats[strings.ToLower(format)]
+	}
+
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+
+		switch mediaType {
+		case rssContentType, atomContentType, jsonFeedContentType, "application/json", "*/*":
+			if q > bestQ {
+				best, bestQ = mediaType, q
+			}
+		}
+	}
+
+	switch best {
+	case rssContentType, atomContentType, jsonFeedContentType:
+		return best
+	default:
+		return ""
+	}
+}
+
+func writeFeed(w http.ResponseWriter, contentType string, page *feedPage) error {
+	var body []byte
+	var err error
+
+	switch contentType {
+	case rssContentType:
+		body, err = marshalXml(newRssFeed(page))
+	case atomContentType:
+		body, err = marshalXml(newAtomFeed(page))
+	case jsonFeedContentType:
+		body, err = json.Marshal(newJsonFeed(page))
+	default:
+		return fmt.Errorf("unsupported feed format %s", contentType)
+	}
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	_, _ = w.Write(body)
+	return nil
+}
+
+func marshalXml(v any) ([]byte, error) {
+	body, err := xml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), body...), nil
+}
+
+func (page *feedPage) title() string {
+	return fmt.Sprintf("News feed of %s", page.User)
+}
+
+func (page *feedPage) updated() time.Time {
+	updated := time.Unix(0, 0).UTC()
+	for _, p := range page.Publications {
+		if p.UpdatedOn.After(updated) {
+			updated = p.UpdatedOn
+		}
+		if p.CreatedOn.After(updated) {
+			updated = p.CreatedOn
+		}
+	}
+
+	return updated
+}
+
+func publicationTitle(p *news.Publication) string {
+	const maxLength = 80
+
+	content := strings.Join(strings.Fields(p.Content), " ")
+	if utf8.RuneCountInString(content) <= maxLength {
+		return content
+	}
+
+	return string([]rune(content)[:maxLength-1]) + "…"
+}
+
+func authorName(p *news.Publication) string {
+	if p.Author == nil {
+		return ""
+	}
+
+	return p.Author.FullName
+}
+
+func mediaType(link string) string {
+	u, err := url.Parse(link)
+	if err == nil {
+		if t := mime.TypeByExtension(path.Ext(u.Path)); t != "" {
+			return t
+		}
+	}
+
+	return "application/octet-stream"
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Atom    string     `xml:"xmlns:atom,attr"`
+	Dc      string     `xml:"xmlns:dc,attr"`
+	Media   string     `xml:"xmlns:media,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string     `xml:"title"`
+	Link          string     `xml:"link"`
+	Description   string     `xml:"description"`
+	LastBuildDate string     `xml:"lastBuildDate"`
+	Links         []atomLink `xml:"atom:link"`
+	Items         []rssItem  `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string     `xml:"title"`
+	Description string     `xml:"description"`
+	Creator     string     `xml:"dc:creator,omitempty"`
+	Guid        rssGuid    `xml:"guid"`
+	PubDate     string     `xml:"pubDate"`
+	Media       []rssMedia `xml:"media:content"`
+}
+
+type rssGuid struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// rssMedia is used instead of enclosure, enclosure requires length of the file which is not stored
+type rssMedia struct {
+	Url  string `xml:"url,attr"`
+	Type string `xml:"type,attr"`
+}
+
+func newRssFeed(page *feedPage) *rssFeed {
+	links := []atomLink{{Href: page.SelfUrl, Rel: "self", Type: rssContentType}}
+	if page.PrevUrl != "" {
+		links = append(links, atomLink{Href: page.PrevUrl, Rel: "previous", Type: rssContentType})
+	}
+	if page.NextUrl != "" {
+		links = append(links, atomLink{Href: page.NextUrl, Rel: "next", Type: rssContentType})
+	}
+
+	items := make([]rssItem, 0, len(page.Publications))
+	for i := range page.Publications {
+		p := &page.Publications[i]
+
+		media := make([]rssMedia, 0, len(p.Media))
+		for _, m := range p.Media {
+			if m == nil {
+				continue
+			}
+			media = append(media, rssMedia{Url: m.Link, Type: mediaType(m.Link)})
+		}
+
+		items = append(items, rssItem{
+			Title:       publicationTitle(p),
+			Description: p.Content,
+			Creator:     authorName(p),
+			Guid:        rssGuid{Value: p.Id},
+			PubDate:     p.CreatedOn.Format(time.RFC1123Z),
+			Media:       media,
+		})
+	}
+
+	return &rssFeed{
+		Version: "2.0",
+		Atom:    "http://www.w3.org/2005/Atom",
+		Dc:      "http://purl.org/dc/elements/1.1/",
+		Media:   "http://search.yahoo.com/mrss/",
+		Channel: rssChannel{
+			Title:         page.title(),
+			Link:          page.FirstUrl,
+			Description:   page.title(),
+			LastBuildDate: page.updated().Format(time.RFC1123Z),
+			Links:         links,
+			Items:         items,
+		},
+	}
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Id      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	Id        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Published string      `xml:"published"`
+	Updated   string      `xml:"updated"`
+	Author    *atomAuthor `xml:"author,omitempty"`
+	Content   atomContent `xml:"content"`
+	Links     []atomLink  `xml:"link"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomContent struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+func newAtomFeed(page *feedPage) *atomFeed {
+	links := []atomLink{
+		{Href: page.SelfUrl, Rel: "self", Type: atomContentType},
+		{Href: page.FirstUrl, Rel: "first", Type: atomContentType},
+	}
+	if page.PrevUrl != "" {
+		links = append(links, atomLink{Href: page.PrevUrl, Rel: "previous", Type: atomContentType})
+	}
+	if page.NextUrl != "" {
+		links = append(links, atomLink{Href: page.NextUrl, Rel: "next", Type: atomContentType})
+	}
+
+	entries := make([]atomEntry, 0, len(page.Publications))
+	for i := range page.Publications {
+		p := &page.Publications[i]
+
+		var author *atomAuthor
+		if p.Author != nil {
+			author = &atomAuthor{Name: p.Author.FullName}
+		}
+
+		enclosures := make([]atomLink, 0, len(p.Media))
+		for _, m := range p.Media {
+			if m == nil {
+				continue
+			}
+			enclosures = append(enclosures, atomLink{Href: m.Link, Rel: "enclosure", Type: mediaType(m.Link)})
+		}
+
+		updated := p.UpdatedOn
+		if updated.Before(p.CreatedOn) {
+			updated = p.CreatedOn
+		}
+
+		entries = append(entries, atomEntry{
+			Id:        fmt.Sprintf("urn:ghostnetwork:publication:%s", p.Id),
+			Title:     publicationTitle(p),
+			Published: p.CreatedOn.Format(time.RFC3339),
+			Updated:   updated.Format(time.RFC3339),
+			Author:    author,
+			Content:   atomContent{Type: "text", Value: p.Content},
+			Links:     enclosures,
+		})
+	}
+
+	return &atomFeed{
+		Id:      fmt.Sprintf("urn:ghostnetwork:newsfeed:%s", page.User),
+		Title:   page.title(),
+		Updated: page.updated().Format(time.RFC3339),
+		Links:   links,
+		Entries: entries,
+	}
+}
+
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageUrl string         `json:"home_page_url"`
+	FeedUrl     string         `json:"feed_url"`
+	NextUrl     string         `json:"next_url,omitempty"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	Id            string               `json:"id"`
+	Title         string               `json:"title"`
+	ContentText   string               `json:"content_text"`
+	DatePublished string               `json:"date_published"`
+	DateModified  string               `json:"date_modified"`
+	Authors       []jsonFeedAuthor     `json:"authors,omitempty"`
+	Attachments   []jsonFeedAttachment `json:"attachments,omitempty"`
+}
+
+type jsonFeedAuthor struct {
+	Name   string `json:"name"`
+	Avatar string `json:"avatar,omitempty"`
+}
+
+type jsonFeedAttachment struct {
+	Url      string `json:"url"`
+	MimeType string `json:"mime_type"`
+}
+
+func newJsonFeed(page *feedPage) *jsonFeed {
+	items := make([]jsonFeedItem, 0, len(page.Publications))
+	for i := range page.Publications {
+		p := &page.Publications[i]
+
+		var authors []jsonFeedAuthor
+		if p.Author != nil {
+			authors = append(authors, jsonFeedAuthor{Name: p.Author.FullName, Avatar: p.Author.AvatarUrl})
+		}
+
+		var attachments []jsonFeedAttachment
+		for _, m := range p.Media {
+			if m == nil {
+				continue
+			}
+			attachments = append(attachments, jsonFeedAttachment{Url: m.Link, MimeType: mediaType(m.Link)})
+		}
+
+		items = append(items, jsonFeedItem{
+			Id:            p.Id,
+			Title:         publicationTitle(p),
+			ContentText:   p.Content,
+			DatePublished: p.CreatedOn.Format(time.RFC3339),
+			DateModified:  p.UpdatedOn.Format(time.RFC3339),
+			Authors:       authors,
+			Attachments:   attachments,
+		})
+	}
+
+	return &jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       page.title(),
+		HomePageUrl: page.FirstUrl,
+		FeedUrl:     page.SelfUrl,
+		NextUrl:     page.NextUrl,
+		Items:       items,
+	}
+}
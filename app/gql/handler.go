@@ -0,0 +1,80 @@
+package gql
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"github.com/ghosts-network/news-feed/news"
+	"github.com/ghosts-network/news-feed/utils/logger"
+	"github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/errors"
+	pkgerrors "github.com/pkg/errors"
+	"net/http"
+)
+
+//go:embed schema.graphql
+var schema string
+
+// ErrorPresenter returns code and message shown to client for error of a resolver,
+// it is responsible for logging errors which are not shown
+type ErrorPresenter func(ctx context.Context, err error) (code string, message string)
+
+type Handler struct {
+	schema       *graphql.Schema
+	storage      *news.MongoNewsStorage
+	presentError ErrorPresenter
+}
+
+func NewHandler(storage *news.MongoNewsStorage, presentError ErrorPresenter) *Handler {
+	return &Handler{
+		schema:       graphql.MustParseSchema(schema, &queryResolver{storage: storage}, graphql.UseFieldResolvers()),
+		storage:      storage,
+		presentError: presentError,
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var params struct {
+		Query         string         `json:"query"`
+		OperationName string         `json:"operationName"`
+		Variables     map[string]any `json:"variables"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&params); err != nil {
+		body, _ := json.Marshal(&graphql.Response{
+			Errors: []*errors.QueryError{errors.Errorf("request body is not valid json")},
+		})
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write(body)
+		return
+	}
+
+	ctx := withPublicationLoader(r.Context(), newPublicationLoader(h.storage))
+	resp := h.schema.Exec(ctx, params.Query, params.OperationName, params.Variables)
+
+	// errors of the query itself are left as is, resolver errors may come from storage
+	for _, queryErr := range resp.Errors {
+		if queryErr.ResolverError == nil {
+			continue
+		}
+
+		code, message := h.presentError(ctx, queryErr.ResolverError)
+		queryErr.Message = message
+		queryErr.Extensions = map[string]any{"code": code}
+	}
+
+	body, err := json.Marshal(resp)
+	if err != nil {
+		logger.Error(pkgerrors.Wrap(err, "Failed to write graphql response"), &map[string]any{
+			"correlationId": ctx.Value("correlationId"),
+		})
+
+		body, _ = json.Marshal(&graphql.Response{
+			Errors: []*errors.QueryError{errors.Errorf("internal error")},
+		})
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write(body)
+		return
+	}
+
+	_, _ = w.Write(body)
+}
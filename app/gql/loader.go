@@ -0,0 +1,100 @@
+package gql
+
+import (
+	"context"
+	"github.com/ghosts-network/news-feed/news"
+	"sync"
+	"time"
+)
+
+const batchWait = 2 * time.Millisecond
+
+type loaderKey struct{}
+
+// publicationLoader collects publication lookups made by concurrently executed
+// resolvers of a single request and fetches them with one query
+type publicationLoader struct {
+	storage *news.MongoNewsStorage
+
+	mu    sync.Mutex
+	cache map[string]*news.Publication
+	batch *publicationBatch
+}
+
+type publicationBatch struct {
+	ids    []string
+	done   chan struct{}
+	result map[string]*news.Publication
+	err    error
+}
+
+func newPublicationLoader(storage *news.MongoNewsStorage) *publicationLoader {
+	return &publicationLoader{
+		storage: storage,
+		cache:   make(map[string]*news.Publication),
+	}
+}
+
+func withPublicationLoader(ctx context.Context, l *publicationLoader) context.Context {
+	return context.WithValue(ctx, loaderKey{}, l)
+}
+
+func publicationLoaderFrom(ctx context.Context) *publicationLoader {
+	return ctx.Value(loaderKey{}).(*publicationLoader)
+}
+
+func (l *publicationLoader) Prime(ps []news.Publication) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for i := range ps {
+		l.cache[ps[i].Id] = &ps[i]
+	}
+}
+
+func (l *publicationLoader) Load(ctx context.Context, id string) (*news.Publication, error) {
+	l.mu.Lock()
+	if p, ok := l.cache[id]; ok {
+		l.mu.Unlock()
+		return p, nil
+	}
+
+	b := l.batch
+	if b == nil {
+		b = &publicationBatch{done: make(chan struct{})}
+		l.batch = b
+		time.AfterFunc(batchWait, func() { l.dispatch(ctx, b) })
+	}
+	b.ids = append(b.ids, id)
+	l.mu.Unlock()
+
+	select {
+	case <-b.done:
+		return b.result[id], b.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (l *publicationLoader) dispatch(ctx context.Context, b *publicationBatch) {
+	l.mu.Lock()
+	if l.batch == b {
+		l.batch = nil
+	}
+	l.mu.Unlock()
+
+	ps, err := l.storage.FindPublications(ctx, b.ids)
+	b.result = make(map[string]*news.Publication, len(ps))
+	for i := range ps {
+		b.result[ps[i].Id] = &ps[i]
+	}
+	b.err = err
+
+	l.mu.Lock()
+	for id, p := range b.result {
+		l.cache[id] = p
+	}
+	l.mu.Unlock()
+
+	close(b.done)
+}
@@ -0,0 +1,231 @@
+package gql
+
+import (
+	"context"
+	"github.com/ghosts-network/news-feed/news"
+	"github.com/graph-gophers/graphql-go"
+)
+
+type queryResolver struct {
+	storage *news.MongoNewsStorage
+}
+
+func (r *queryResolver) Feed(ctx context.Context, args struct {
+	User  graphql.ID
+	First *int32
+	After *string
+}) (*feedConnectionResolver, error) {
+	take := 20
+	if args.First != nil && 0 < *args.First && *args.First <= 100 {
+		take = int(*args.First)
+	}
+
+	cursor := ""
+	if args.After != nil {
+		cursor = *args.After
+	}
+
+	// one extra item tells whether next page exists
+	ps, err := r.storage.FindNews(ctx, string(args.User), cursor, take+1)
+	if err != nil {
+		return nil, err
+	}
+
+	hasNextPage := len(ps) > take
+	if hasNextPage {
+		ps = ps[:take]
+	}
+	publicationLoaderFrom(ctx).Prime(ps)
+
+	// feed is paged forward only, so items before the page exist whenever it starts after a cursor
+	return &feedConnectionResolver{publications: ps, hasNextPage: hasNextPage, hasPreviousPage: cursor != ""}, nil
+}
+
+func (r *queryResolver) Publication(ctx context.Context, args struct{ Id graphql.ID }) (*publicationResolver, error) {
+	p, err := publicationLoaderFrom(ctx).Load(ctx, string(args.Id))
+	if err != nil || p == nil {
+		return nil, err
+	}
+
+	return &publicationResolver{p}, nil
+}
+
+func (r *queryResolver) Sources(ctx context.Context, args struct {
+	User graphql.ID
+	Skip *int32
+	Take *int32
+}) ([]*sourceResolver, error) {
+	skip, take := 0, 20
+	if args.Skip != nil && *args.Skip > 0 {
+		skip = int(*args.Skip)
+	}
+	if args.Take != nil && 0 < *args.Take && *args.Take <= 100 {
+		take = int(*args.Take)
+	}
+
+	sources, err := r.storage.FindUserSources(ctx, string(args.User), skip, take)
+	if err != nil {
+		return nil, err
+	}
+
+	resolvers := make([]*sourceResolver, 0, len(sources))
+	for _, source := range sources {
+		resolvers = append(resolvers, &sourceResolver{source})
+	}
+
+	return resolvers, nil
+}
+
+type feedConnectionResolver struct {
+	publications    []news.Publication
+	hasNextPage     bool
+	hasPreviousPage bool
+}
+
+func (r *feedConnectionResolver) Edges() []*feedEdgeResolver {
+	edges := make([]*feedEdgeResolver, 0, len(r.publications))
+	for i := range r.publications {
+		edges = append(edges, &feedEdgeResolver{&r.publications[i]})
+	}
+
+	return edges
+}
+
+func (r *feedConnectionResolver) PageInfo() *pageInfoResolver {
+	info := &pageInfoResolver{HasNextPage: r.hasNextPage, HasPreviousPage: r.hasPreviousPage}
+	if len(r.publications) > 0 {
+		info.StartCursor = &r.publications[0].Id
+		info.EndCursor = &r.publications[len(r.publications)-1].Id
+	}
+
+	return info
+}
+
+type feedEdgeResolver struct {
+	p *news.Publication
+}
+
+func (r *feedEdgeResolver) Cursor() string {
+	return r.p.Id
+}
+
+func (r *feedEdgeResolver) Node() *publicationResolver {
+	return &publicationResolver{r.p}
+}
+
+func (r *feedEdgeResolver) Provenance() *provenanceResolver {
+	if r.p.Provenance == nil {
+		return nil
+	}
+
+	return &provenanceResolver{r.p.Provenance}
+}
+
+type provenanceResolver struct {
+	p *news.Provenance
+}
+
+func (r *provenanceResolver) Source() graphql.ID {
+	return graphql.ID(r.p.Source)
+}
+
+func (r *provenanceResolver) Reason() *string {
+	return toSourceKind(r.p.Reason)
+}
+
+type sourceResolver struct {
+	s news.Source
+}
+
+func (r *sourceResolver) Id() graphql.ID {
+	return graphql.ID(r.s.Id)
+}
+
+func (r *sourceResolver) Kind() *string {
+	return toSourceKind(r.s.Kind)
+}
+
+// toSourceKind returns value of SourceKind enum, nil for sources stored without kind
+func toSourceKind(kind news.SourceKind) *string {
+	var value string
+	switch kind {
+	case news.FriendSource:
+		value = "FRIEND"
+	case news.PendingRequestSource:
+		value = "PENDING_REQUEST"
+	default:
+		return nil
+	}
+
+	return &value
+}
+
+type pageInfoResolver struct {
+	StartCursor     *string
+	EndCursor       *string
+	HasNextPage     bool
+	HasPreviousPage bool
+}
+
+type publicationResolver struct {
+	p *news.Publication
+}
+
+func (r *publicationResolver) Id() graphql.ID {
+	return graphql.ID(r.p.Id)
+}
+
+func (r *publicationResolver) Content() string {
+	return r.p.Content
+}
+
+func (r *publicationResolver) Author() *authorResolver {
+	if r.p.Author == nil {
+		return nil
+	}
+
+	return &authorResolver{r.p.Author}
+}
+
+func (r *publicationResolver) CreatedOn() graphql.Time {
+	return graphql.Time{Time: r.p.CreatedOn}
+}
+
+func (r *publicationResolver) UpdatedOn() graphql.Time {
+	return graphql.Time{Time: r.p.UpdatedOn}
+}
+
+func (r *publicationResolver) Media() []*mediaResolver {
+	media := make([]*mediaResolver, 0, len(r.p.Media))
+	for _, m := range r.p.Media {
+		if m != nil {
+			media = append(media, &mediaResolver{m})
+		}
+	}
+
+	return media
+}
+
+type authorResolver struct {
+	a *news.PublicationAuthor
+}
+
+func (r *authorResolver) Id() graphql.ID {
+	return graphql.ID(r.a.Id)
+}
+
+func (r *authorResolver) FullName() string {
+	return r.a.FullName
+}
+
+func (r *authorResolver) AvatarUrl() string {
+	return r.a.AvatarUrl
+}
+
+type mediaResolver struct {
+	m *news.Media
+}
+
+func (r *mediaResolver) Link() string {
+	return r.m.Link
+}
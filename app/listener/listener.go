@@ -2,14 +2,15 @@ package listener
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
 	"github.com/ghosts-network/news-feed/infrastructure"
 	"github.com/ghosts-network/news-feed/news"
+	"github.com/ghosts-network/news-feed/utils/env"
+	"github.com/ghosts-network/news-feed/utils/health"
 	"github.com/ghosts-network/news-feed/utils/logger"
+	"github.com/nats-io/nats.go"
 	"github.com/pkg/errors"
-	amqp "github.com/rabbitmq/amqp091-go"
 	"log"
 	"os"
 	"strings"
@@ -19,19 +20,24 @@ import (
 const subscriptionName string = "ghostnetwork.newsfeed"
 
 type Listener struct {
-	exit <-chan os.Signal
+	storage *news.MongoNewsStorage
+	router  *Router
+	checks  *health.Checks
 }
 
-func NewListener(exit <-chan os.Signal) *Listener {
-	return &Listener{exit: exit}
+// HealthReporter is implemented by event buses which can lose connection to the broker
+type HealthReporter interface {
+	Health() error
 }
 
-func (l Listener) Run() {
-	log.SetFlags(0)
+func NewListener(storage *news.MongoNewsStorage, checks *health.Checks) *Listener {
+	return &Listener{storage: storage, router: newRouter(storage), checks: checks}
+}
 
-	storage := news.NewMongoNewsStorage(os.Getenv("MONGO_CONNECTION"))
+func (l Listener) Run(exit context.Context) {
+	log.SetFlags(0)
 
-	ctx := context.Background()
+	ctx := exit
 
 	eventbus, err := getEventBus()
 	if err != nil {
@@ -39,132 +45,111 @@ func (l Listener) Run() {
 		return
 	}
 
-	err = eventbus.ListenOne(ctx, "ghostnetwork.content.publications.created", subscriptionName, func(ctx context.Context, message []byte) error {
-		var model news.Publication
-		err := json.Unmarshal(message, &model)
-		if err != nil {
-			return err
-		}
-
-		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
-		defer cancel()
-
-		return storage.AddPublication(ctx, &model)
-	})
-	if err != nil {
-		logger.Error(errors.Wrap(err, "Failed to subscribe on ghostnetwork.content.publications.created"), &map[string]any{})
-	} else {
-		logger.Info(fmt.Sprintf("Successfully subscribed to topic ghostnetwork.content.publications.created"), &map[string]any{})
+	if reporter, ok := eventbus.(HealthReporter); ok {
+		l.checks.Register("eventbus", func(ctx context.Context) error {
+			return reporter.Health()
+		})
 	}
 
-	err = eventbus.ListenOne(ctx, "ghostnetwork.content.publications.updated", subscriptionName, func(ctx context.Context, message []byte) error {
-		var model news.Publication
-		err := json.Unmarshal(message, &model)
+	if ttl := env.Duration("LISTENER_DEDUPLICATION_TTL", 0); ttl > 0 {
+		processed, err := l.storage.ProcessedMessages(ctx, ttl)
 		if err != nil {
-			return err
+			logger.Error(errors.Wrap(err, "Failed to prepare deduplication store"), &map[string]any{})
+			return
 		}
-
-		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
-		defer cancel()
-
-		return storage.UpdatePublication(ctx, &model)
-	})
-	if err != nil {
-		logger.Error(errors.Wrap(err, "Failed to subscribe on ghostnetwork.content.publications.updated"), &map[string]any{})
-	} else {
-		logger.Info(fmt.Sprintf("Successfully subscribed to topic ghostnetwork.content.publications.updated"), &map[string]any{})
+		l.router.Deduplicate(processed)
 	}
 
-	err = eventbus.ListenOne(ctx, "ghostnetwork.content.publications.deleted", subscriptionName, func(ctx context.Context, message []byte) error {
-		var model news.Publication
-		err := json.Unmarshal(message, &model)
-		if err != nil {
-			return err
-		}
-
-		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
-		defer cancel()
-		return storage.RemovePublication(ctx, &model)
-	})
-	if err != nil {
-		logger.Error(errors.Wrap(err, "Failed to subscribe on ghostnetwork.content.publications.deleted"), &map[string]any{})
-	} else {
-		logger.Info(fmt.Sprintf("Successfully subscribed to topic ghostnetwork.content.publications.deleted"), &map[string]any{})
+	for _, route := range l.Topics() {
+		logger.Info(fmt.Sprintf("Handling %s events of topic %s", route.Event, route.Topic), &map[string]any{
+			"topic": route.Topic,
+		})
 	}
+	l.router.Subscribe(ctx, eventbus, subscriptionName, concurrencyFromEnv)
 
-	err = eventbus.ListenOne(ctx, "ghostnetwork.profiles.friends.requestsent", subscriptionName, func(ctx context.Context, message []byte) error {
-		var model RequestSent
-		err := json.Unmarshal(message, &model)
-		if err != nil {
-			return err
-		}
+	<-exit.Done()
+	logger.Info("Shutting down listener", &map[string]any{})
 
-		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
-		defer cancel()
+	// consumption is stopped by exit, in-flight messages are given time to finish
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), env.Duration("LISTENER_SHUTDOWN_TIMEOUT", 20*time.Second))
+	defer cancel()
 
-		return storage.AddUserSource(ctx, model.FromUser, model.ToUser)
-	})
-	if err != nil {
-		logger.Error(errors.Wrap(err, "Failed to subscribe on ghostnetwork.profiles.friends.requestsent"), &map[string]any{})
-	} else {
-		logger.Info(fmt.Sprintf("Successfully subscribed to topic ghostnetwork.profiles.friends.requestsent"), &map[string]any{})
+	if err := eventbus.Close(shutdownCtx); err != nil {
+		logger.Error(errors.Wrap(err, "Listener did not stop gracefully"), &map[string]any{})
+		return
 	}
 
-	err = eventbus.ListenOne(ctx, "ghostnetwork.profiles.friends.requestcancelled", subscriptionName, func(ctx context.Context, message []byte) error {
-		var model RequestCancelled
-		err := json.Unmarshal(message, &model)
-		if err != nil {
-			return err
-		}
+	logger.Info("Listener stopped", &map[string]any{})
+}
 
-		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
-		defer cancel()
+// Topics returns events handled by listener
+func (l Listener) Topics() []Route {
+	return l.router.Topics()
+}
 
-		return storage.RemoveUserSource(ctx, model.FromUser, model.ToUser)
-	})
-	if err != nil {
-		logger.Error(errors.Wrap(err, "Failed to subscribe on ghostnetwork.profiles.friends.requestcancelled"), &map[string]any{})
-	} else {
-		logger.Info(fmt.Sprintf("Successfully subscribed to topic ghostnetwork.profiles.friends.requestcancelled"), &map[string]any{})
-	}
+func newRouter(storage *news.MongoNewsStorage) *Router {
+	router := NewRouter(
+		recoveryMiddleware,
+		loggingMiddleware,
+		timeoutMiddleware(env.Duration("LISTENER_HANDLER_TIMEOUT", 30*time.Second)))
+
+	Handle(router, "ghostnetwork.content.publications.created", func(ctx context.Context, event news.Publication) error {
+		return storage.AddPublication(ctx, &event)
+	}, IdempotencyKey(publicationId), OrderingKey(publicationId))
+	Handle(router, "ghostnetwork.content.publications.updated", func(ctx context.Context, event news.Publication) error {
+		return storage.UpdatePublication(ctx, &event)
+	}, OrderingKey(publicationId))
+	Handle(router, "ghostnetwork.content.publications.deleted", func(ctx context.Context, event news.Publication) error {
+		return storage.RemovePublication(ctx, &event)
+	}, IdempotencyKey(publicationId), OrderingKey(publicationId))
+	Handle(router, "ghostnetwork.profiles.friends.requestsent", func(ctx context.Context, event RequestSent) error {
+		err := storage.AddUserSource(ctx, event.FromUser, event.ToUser, news.PendingRequestSource)
+		if errors.Is(err, news.ErrSourceExists) {
+			return nil
+		}
 
-	err = eventbus.ListenOne(ctx, "ghostnetwork.profiles.friends.requestapproved", subscriptionName, func(ctx context.Context, message []byte) error {
-		var model RequestApproved
-		err := json.Unmarshal(message, &model)
-		if err != nil {
+		return err
+	}, OrderingKey(func(event RequestSent) string { return usersPair(event.FromUser, event.ToUser) }))
+	Handle(router, "ghostnetwork.profiles.friends.requestcancelled", func(ctx context.Context, event RequestCancelled) error {
+		return storage.RemoveUserSource(ctx, event.FromUser, event.ToUser)
+	}, OrderingKey(func(event RequestCancelled) string { return usersPair(event.FromUser, event.ToUser) }))
+	Handle(router, "ghostnetwork.profiles.friends.requestapproved", func(ctx context.Context, event RequestApproved) error {
+		err := storage.AddUserSource(ctx, event.User, event.Requester, news.FriendSource)
+		if err != nil && !errors.Is(err, news.ErrSourceExists) {
 			return err
 		}
 
-		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
-		defer cancel()
+		return storage.UpdateUserSourceKind(ctx, event.Requester, event.User, news.FriendSource)
+	}, OrderingKey(func(event RequestApproved) string { return usersPair(event.User, event.Requester) }))
+	Handle(router, "ghostnetwork.profiles.friends.deleted", func(ctx context.Context, event Deleted) error {
+		return storage.RemoveUserSource(ctx, event.User, event.Friend)
+	}, OrderingKey(func(event Deleted) string { return usersPair(event.User, event.Friend) }))
 
-		return storage.AddUserSource(ctx, model.User, model.Requester)
-	})
-	if err != nil {
-		logger.Error(errors.Wrap(err, "Failed to subscribe on ghostnetwork.profiles.friends.requestapproved"), &map[string]any{})
-	} else {
-		logger.Info(fmt.Sprintf("Successfully subscribed to topic ghostnetwork.profiles.friends.requestapproved"), &map[string]any{})
+	return router
+}
+
+func publicationId(event news.Publication) string {
+	return event.Id
+}
+
+// usersPair doesn't depend on direction of relation, so events of both users are ordered together
+func usersPair(a string, b string) string {
+	if a > b {
+		a, b = b, a
 	}
 
-	err = eventbus.ListenOne(ctx, "ghostnetwork.profiles.friends.deleted", subscriptionName, func(ctx context.Context, message []byte) error {
-		var model Deleted
-		err := json.Unmarshal(message, &model)
-		if err != nil {
-			return err
-		}
+	return a + "/" + b
+}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
+// concurrencyFromEnv reads workers and prefetch of the topic, e.g. LISTENER_WORKERS_GHOSTNETWORK_CONTENT_PUBLICATIONS_CREATED,
+// and falls back to LISTENER_WORKERS and LISTENER_PREFETCH
+func concurrencyFromEnv(topic string) (int, int) {
+	suffix := "_" + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(topic))
 
-		return storage.RemoveUserSource(ctx, model.User, model.Friend)
-	})
-	if err != nil {
-		logger.Error(errors.Wrap(err, "Failed to subscribe on ghostnetwork.profiles.friends.deleted"), &map[string]any{})
-	} else {
-		logger.Info(fmt.Sprintf("Successfully subscribed to topic ghostnetwork.profiles.friends.deleted"), &map[string]any{})
-	}
+	workers := env.Int("LISTENER_WORKERS"+suffix, env.Int("LISTENER_WORKERS", 1))
+	prefetch := env.Int("LISTENER_PREFETCH"+suffix, env.Int("LISTENER_PREFETCH", 10))
 
-	<-l.exit
+	return workers, prefetch
 }
 
 func getEventBus() (EventListener, error) {
@@ -174,6 +159,12 @@ func getEventBus() (EventListener, error) {
 	} else if strings.ToLower(os.Getenv("EVENTHUB_TYPE")) == "rabbit" {
 		eventbus, err := configureRabbit(os.Getenv("RABBIT_CONNECTION"))
 		return eventbus, err
+	} else if strings.ToLower(os.Getenv("EVENTHUB_TYPE")) == "kafka" {
+		eventbus, err := configureKafka(os.Getenv("KAFKA_BROKERS"))
+		return eventbus, err
+	} else if strings.ToLower(os.Getenv("EVENTHUB_TYPE")) == "nats" {
+		eventbus, err := configureNats(os.Getenv("NATS_CONNECTION"))
+		return eventbus, err
 	} else {
 		return &NullEventListener{}, nil
 	}
@@ -181,21 +172,112 @@ func getEventBus() (EventListener, error) {
 
 func configureServiceBus(connectionString string) (*infrastructure.ServiceBus, error) {
 	client, err := azservicebus.NewClientFromConnectionString(connectionString, nil)
-	return infrastructure.NewServiceBus(client), err
+	options := infrastructure.DefaultServiceBusOptions()
+	options.BatchSize = env.Int("SERVICEBUS_BATCH_SIZE", options.BatchSize)
+	options.ReceiveBackoff = infrastructure.NewRetryPolicy(0,
+		env.Duration("SERVICEBUS_RECEIVE_BACKOFF", options.ReceiveBackoff.Backoff),
+		env.Duration("SERVICEBUS_RECEIVE_MAX_BACKOFF", options.ReceiveBackoff.MaxBackoff))
+	options.MaxLockRenewal = env.Duration("SERVICEBUS_MAX_LOCK_RENEWAL", options.MaxLockRenewal)
+
+	return infrastructure.NewServiceBus(client, retryPolicyFromEnv(), options), err
 }
 
 func configureRabbit(connectionString string) (*infrastructure.RabbitMq, error) {
-	conn, err := amqp.Dial(connectionString)
-	return infrastructure.NewRabbitMq(conn), err
+	reconnect := infrastructure.NewRetryPolicy(0,
+		env.Duration("RABBIT_RECONNECT_BACKOFF", time.Second),
+		env.Duration("RABBIT_RECONNECT_MAX_BACKOFF", 30*time.Second))
+
+	return infrastructure.DialRabbitMq(connectionString, rabbitTopologyFromEnv(), retryPolicyFromEnv(), reconnect)
+}
+
+// configureKafka reads dead-letter topic of the consumers, KAFKA_DEAD_LETTER_TOPIC=none disables dead-lettering
+func configureKafka(brokers string) (*infrastructure.Kafka, error) {
+	if brokers == "" {
+		return nil, errors.New("KAFKA_BROKERS is required")
+	}
+
+	options := infrastructure.DefaultKafkaOptions()
+	options.DeadLetterTopic = env.String("KAFKA_DEAD_LETTER_TOPIC", options.DeadLetterTopic)
+	if strings.ToLower(options.DeadLetterTopic) == "none" {
+		options.DeadLetterTopic = ""
+	}
+	options.DeadLetterBackoff = infrastructure.NewRetryPolicy(0,
+		env.Duration("KAFKA_DEAD_LETTER_BACKOFF", options.DeadLetterBackoff.Backoff),
+		env.Duration("KAFKA_DEAD_LETTER_MAX_BACKOFF", options.DeadLetterBackoff.MaxBackoff))
+
+	return infrastructure.NewKafka(strings.Split(brokers, ","), retryPolicyFromEnv(), options), nil
 }
 
+// configureNats connects to the server and reads options of durable consumers, NATS_BACKOFF is a comma separated list
+func configureNats(connectionString string) (*infrastructure.NatsJetStream, error) {
+	// client restores lost connection itself, pending fetches fail until then
+	conn, err := nats.Connect(connectionString, nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, err
+	}
+
+	options := infrastructure.DefaultNatsOptions()
+	options.AckWait = env.Duration("NATS_ACK_WAIT", options.AckWait)
+	options.MaxDeliver = env.Int("NATS_MAX_DELIVER", options.MaxDeliver)
+	options.FetchBackoff = infrastructure.NewRetryPolicy(0,
+		env.Duration("NATS_FETCH_BACKOFF", options.FetchBackoff.Backoff),
+		env.Duration("NATS_FETCH_MAX_BACKOFF", options.FetchBackoff.MaxBackoff))
+
+	for _, value := range strings.Split(os.Getenv("NATS_BACKOFF"), ",") {
+		if value = strings.TrimSpace(value); value == "" {
+			continue
+		}
+
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, errors.Wrap(err, "NATS_BACKOFF is invalid")
+		}
+		options.BackOff = append(options.BackOff, d)
+	}
+
+	return infrastructure.NewNatsJetStream(conn, retryPolicyFromEnv(), options)
+}
+
+// rabbitTopologyFromEnv reads topology overrides, RABBIT_DEAD_LETTER_EXCHANGE=none disables dead-lettering
+func rabbitTopologyFromEnv() infrastructure.RabbitTopology {
+	topology := infrastructure.DefaultRabbitTopology()
+
+	deadLetterExchange := env.String("RABBIT_DEAD_LETTER_EXCHANGE", topology.DeadLetterExchange)
+	if strings.ToLower(deadLetterExchange) == "none" {
+		deadLetterExchange = ""
+	}
+
+	return infrastructure.RabbitTopology{
+		ExchangeType:       env.String("RABBIT_EXCHANGE_TYPE", topology.ExchangeType),
+		Durable:            env.Bool("RABBIT_DURABLE", topology.Durable),
+		QueueName:          env.String("RABBIT_QUEUE_NAME", topology.QueueName),
+		BindingKey:         env.String("RABBIT_BINDING_KEY", topology.BindingKey),
+		MessageTtl:         env.Duration("RABBIT_MESSAGE_TTL", topology.MessageTtl),
+		MaxLength:          env.Int("RABBIT_MAX_LENGTH", topology.MaxLength),
+		DeadLetterExchange: deadLetterExchange,
+	}
+}
+
+func retryPolicyFromEnv() infrastructure.RetryPolicy {
+	return infrastructure.NewRetryPolicy(
+		env.Int("LISTENER_MAX_ATTEMPTS", 5),
+		env.Duration("LISTENER_RETRY_BACKOFF", time.Second),
+		env.Duration("LISTENER_RETRY_MAX_BACKOFF", 30*time.Second))
+}
+
+// EventListener consumes topic until ctx passed to ListenOne is done, Close waits for in-flight messages and releases connections
 type EventListener interface {
-	ListenOne(ctx context.Context, topicName string, subscriptionName string, handler func(context.Context, []byte) error) error
+	ListenOne(ctx context.Context, topicName string, subscriptionName string, handler func(context.Context, []byte) error, options infrastructure.ConsumerOptions) error
+	Close(ctx context.Context) error
 }
 
 type NullEventListener struct {
 }
 
-func (n NullEventListener) ListenOne(ctx context.Context, topicName string, subscriptionName string, handler func(context.Context, []byte) error) error {
+func (n NullEventListener) ListenOne(ctx context.Context, topicName string, subscriptionName string, handler func(context.Context, []byte) error, options infrastructure.ConsumerOptions) error {
+	return nil
+}
+
+func (n NullEventListener) Close(ctx context.Context) error {
 	return nil
 }
@@ -0,0 +1,249 @@
+package listener
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ghosts-network/news-feed/infrastructure"
+	"github.com/ghosts-network/news-feed/utils/logger"
+	"github.com/pkg/errors"
+	"reflect"
+	"time"
+)
+
+type MessageHandler func(ctx context.Context, message []byte) error
+
+// Middleware wraps handler of the route, route describes topic and event handled by next
+type Middleware func(route Route, next MessageHandler) MessageHandler
+
+type Route struct {
+	Topic string `json:"topic"`
+	Event string `json:"event"`
+}
+
+// DecodeError means message can't be handled no matter how many times it is delivered
+type DecodeError struct {
+	Event string
+	Err   error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("failed to decode %s: %s", e.Event, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent marks error as not retryable
+func (e *DecodeError) Permanent() bool {
+	return true
+}
+
+// Deduplicator claims messages before they are handled, so concurrent duplicates are handled once
+type Deduplicator interface {
+	Claim(ctx context.Context, key string) (bool, error)
+	Release(ctx context.Context, key string) error
+}
+
+// releaseTimeout bounds release of the claim, which is done even when handling was cancelled
+const releaseTimeout = 5 * time.Second
+
+type Router struct {
+	middlewares  []Middleware
+	routes       []Route
+	handlers     map[string]MessageHandler
+	orderingKeys map[string]func(message []byte) string
+	deduplicator Deduplicator
+}
+
+func NewRouter(middlewares ...Middleware) *Router {
+	return &Router{
+		middlewares:  middlewares,
+		handlers:     make(map[string]MessageHandler),
+		orderingKeys: make(map[string]func(message []byte) string),
+	}
+}
+
+type routeOptions[T any] struct {
+	idempotencyKey func(event T) string
+	orderingKey    func(event T) string
+}
+
+type RouteOption[T any] func(options *routeOptions[T])
+
+// IdempotencyKey makes duplicates be detected by key of the event instead of id of the message.
+// It should only be used for events which can't legitimately happen twice, e.g. creation of an entity
+func IdempotencyKey[T any](key func(event T) string) RouteOption[T] {
+	return func(options *routeOptions[T]) {
+		options.idempotencyKey = key
+	}
+}
+
+// OrderingKey makes events with the same key be handled one by one in order of delivery,
+// events with different keys may be handled concurrently
+func OrderingKey[T any](key func(event T) string) RouteOption[T] {
+	return func(options *routeOptions[T]) {
+		options.orderingKey = key
+	}
+}
+
+// Handle binds topic to handler of typed event, message body is decoded from json before handler is called
+func Handle[T any](router *Router, topic string, handler func(ctx context.Context, event T) error, opts ...RouteOption[T]) {
+	if _, ok := router.handlers[topic]; ok {
+		panic(fmt.Sprintf("topic %s is already handled", topic))
+	}
+
+	var options routeOptions[T]
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	route := Route{Topic: topic, Event: reflect.TypeOf((*T)(nil)).Elem().Name()}
+
+	h := func(ctx context.Context, message []byte) error {
+		var event T
+		if err := json.Unmarshal(message, &event); err != nil {
+			return &DecodeError{Event: route.Event, Err: err}
+		}
+
+		if router.deduplicator == nil {
+			return handler(ctx, event)
+		}
+
+		id, _ := ctx.Value("messageId").(string)
+		if options.idempotencyKey != nil {
+			id = options.idempotencyKey(event)
+		}
+
+		return router.deduplicate(ctx, route, id, func() error {
+			return handler(ctx, event)
+		})
+	}
+
+	for i := len(router.middlewares) - 1; i >= 0; i-- {
+		h = router.middlewares[i](route, h)
+	}
+
+	router.routes = append(router.routes, route)
+	router.handlers[topic] = h
+
+	if options.orderingKey != nil {
+		router.orderingKeys[topic] = func(message []byte) string {
+			var event T
+			if err := json.Unmarshal(message, &event); err != nil {
+				return ""
+			}
+
+			return options.orderingKey(event)
+		}
+	}
+}
+
+// Deduplicate makes router skip messages which were already handled
+func (router *Router) Deduplicate(deduplicator Deduplicator) {
+	router.deduplicator = deduplicator
+}
+
+func (router *Router) deduplicate(ctx context.Context, route Route, id string, handle func() error) error {
+	if id == "" {
+		return handle()
+	}
+
+	key := route.Topic + "/" + id
+	claimed, err := router.deduplicator.Claim(ctx, key)
+	if err != nil {
+		return errors.Wrap(err, "Failed to check for duplicate")
+	}
+	if !claimed {
+		logger.Info(fmt.Sprintf("%s %s is already handled, skipped", route.Event, id), &map[string]any{
+			"correlationId": ctx.Value("correlationId"),
+			"topic":         route.Topic,
+		})
+		return nil
+	}
+
+	if err := handle(); err != nil {
+		releaseCtx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+		defer cancel()
+
+		// claim which is not released makes redelivery skipped until it expires
+		if releaseErr := router.deduplicator.Release(releaseCtx, key); releaseErr != nil {
+			logger.Error(errors.Wrap(releaseErr, fmt.Sprintf("Failed to release %s %s", route.Event, id)), &map[string]any{
+				"correlationId": ctx.Value("correlationId"),
+				"topic":         route.Topic,
+			})
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// Topics returns handled topics in order of registration
+func (router *Router) Topics() []Route {
+	return append([]Route(nil), router.routes...)
+}
+
+// Subscribe starts listening of every registered topic, failed subscriptions are logged and skipped.
+// concurrency returns number of workers and prefetched messages for the topic
+func (router *Router) Subscribe(ctx context.Context, eventbus EventListener, subscriptionName string, concurrency func(topic string) (int, int)) {
+	for _, route := range router.routes {
+		workers, prefetch := concurrency(route.Topic)
+		options := infrastructure.NewConsumerOptions(workers, prefetch, router.orderingKeys[route.Topic])
+
+		err := eventbus.ListenOne(ctx, route.Topic, subscriptionName, router.handlers[route.Topic], options)
+		if err != nil {
+			logger.Error(errors.Wrap(err, fmt.Sprintf("Failed to subscribe on %s", route.Topic)), &map[string]any{})
+		} else {
+			logger.Info(fmt.Sprintf("Successfully subscribed to topic %s", route.Topic), &map[string]any{})
+		}
+	}
+}
+
+// recoveryMiddleware turns panic of the handler into error, so message is settled as failed instead of crashing listener
+func recoveryMiddleware(route Route, next MessageHandler) MessageHandler {
+	return func(ctx context.Context, message []byte) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = errors.Errorf("handler of %s panicked: %v", route.Event, r)
+			}
+		}()
+
+		return next(ctx, message)
+	}
+}
+
+func loggingMiddleware(route Route, next MessageHandler) MessageHandler {
+	return func(ctx context.Context, message []byte) error {
+		st := time.Now()
+
+		logger.Debug(fmt.Sprintf("%s handling started", route.Event), &map[string]any{
+			"correlationId": ctx.Value("correlationId"),
+			"topic":         route.Topic,
+		})
+
+		err := next(ctx, message)
+
+		logger.Debug(fmt.Sprintf("%s handling finished", route.Event), &map[string]any{
+			"correlationId":       ctx.Value("correlationId"),
+			"topic":               route.Topic,
+			"succeeded":           err == nil,
+			"elapsedMilliseconds": time.Now().Sub(st).Milliseconds(),
+		})
+
+		return err
+	}
+}
+
+func timeoutMiddleware(timeout time.Duration) Middleware {
+	return func(route Route, next MessageHandler) MessageHandler {
+		return func(ctx context.Context, message []byte) error {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			return next(ctx, message)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             v3.21.12
+// source: newsfeed.proto
+
+package pb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// NewsFeedClient is the client API for NewsFeed service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type NewsFeedClient interface {
+	GetFeed(ctx context.Context, in *GetFeedRequest, opts ...grpc.CallOption) (*GetFeedResponse, error)
+	GetPublication(ctx context.Context, in *GetPublicationRequest, opts ...grpc.CallOption) (*Publication, error)
+	AddSource(ctx context.Context, in *AddSourceRequest, opts ...grpc.CallOption) (*AddSourceResponse, error)
+	RemoveSource(ctx context.Context, in *RemoveSourceRequest, opts ...grpc.CallOption) (*RemoveSourceResponse, error)
+}
+
+type newsFeedClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewNewsFeedClient(cc grpc.ClientConnInterface) NewsFeedClient {
+	return &newsFeedClient{cc}
+}
+
+func (c *newsFeedClient) GetFeed(ctx context.Context, in *GetFeedRequest, opts ...grpc.CallOption) (*GetFeedResponse, error) {
+	out := new(GetFeedResponse)
+	err := c.cc.Invoke(ctx, "/ghostnetwork.newsfeed.v1.NewsFeed/GetFeed", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *newsFeedClient) GetPublication(ctx context.Context, in *GetPublicationRequest, opts ...grpc.CallOption) (*Publication, error) {
+	out := new(Publication)
+	err := c.cc.Invoke(ctx, "/ghostnetwork.newsfeed.v1.NewsFeed/GetPublication", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *newsFeedClient) AddSource(ctx context.Context, in *AddSourceRequest, opts ...grpc.CallOption) (*AddSourceResponse, error) {
+	out := new(AddSourceResponse)
+	err := c.cc.Invoke(ctx, "/ghostnetwork.newsfeed.v1.NewsFeed/AddSource", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *newsFeedClient) RemoveSource(ctx context.Context, in *RemoveSourceRequest, opts ...grpc.CallOption) (*RemoveSourceResponse, error) {
+	out := new(RemoveSourceResponse)
+	err := c.cc.Invoke(ctx, "/ghostnetwork.newsfeed.v1.NewsFeed/RemoveSource", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// NewsFeedServer is the server API for NewsFeed service.
+// All implementations must embed UnimplementedNewsFeedServer
+// for forward compatibility
+type NewsFeedServer interface {
+	GetFeed(context.Context, *GetFeedRequest) (*GetFeedResponse, error)
+	GetPublication(context.Context, *GetPublicationRequest) (*Publication, error)
+	AddSource(context.Context, *AddSourceRequest) (*AddSourceResponse, error)
+	RemoveSource(context.Context, *RemoveSourceRequest) (*RemoveSourceResponse, error)
+	mustEmbedUnimplementedNewsFeedServer()
+}
+
+// UnimplementedNewsFeedServer must be embedded to have forward compatible implementations.
+type UnimplementedNewsFeedServer struct {
+}
+
+func (UnimplementedNewsFeedServer) GetFeed(context.Context, *GetFeedRequest) (*GetFeedResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetFeed not implemented")
+}
+func (UnimplementedNewsFeedServer) GetPublication(context.Context, *GetPublicationRequest) (*Publication, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetPublication not implemented")
+}
+func (UnimplementedNewsFeedServer) AddSource(context.Context, *AddSourceRequest) (*AddSourceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddSource not implemented")
+}
+func (UnimplementedNewsFeedServer) RemoveSource(context.Context, *RemoveSourceRequest) (*RemoveSourceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveSource not implemented")
+}
+func (UnimplementedNewsFeedServer) mustEmbedUnimplementedNewsFeedServer() {}
+
+// UnsafeNewsFeedServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to NewsFeedServer will
+// result in compilation errors.
+type UnsafeNewsFeedServer interface {
+	mustEmbedUnimplementedNewsFeedServer()
+}
+
+func RegisterNewsFeedServer(s grpc.ServiceRegistrar, srv NewsFeedServer) {
+	s.RegisterService(&NewsFeed_ServiceDesc, srv)
+}
+
+func _NewsFeed_GetFeed_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetFeedRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NewsFeedServer).GetFeed(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ghostnetwork.newsfeed.v1.NewsFeed/GetFeed",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NewsFeedServer).GetFeed(ctx, req.(*GetFeedRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NewsFeed_GetPublication_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetPublicationRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NewsFeedServer).GetPublication(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ghostnetwork.newsfeed.v1.NewsFeed/GetPublication",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NewsFeedServer).GetPublication(ctx, req.(*GetPublicationRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NewsFeed_AddSource_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddSourceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NewsFeedServer).AddSource(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ghostnetwork.newsfeed.v1.NewsFeed/AddSource",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NewsFeedServer).AddSource(ctx, req.(*AddSourceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _NewsFeed_RemoveSource_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemoveSourceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(NewsFeedServer).RemoveSource(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ghostnetwork.newsfeed.v1.NewsFeed/RemoveSource",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(NewsFeedServer).RemoveSource(ctx, req.(*RemoveSourceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// NewsFeed_ServiceDesc is the grpc.ServiceDesc for NewsFeed service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var NewsFeed_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "ghostnetwork.newsfeed.v1.NewsFeed",
+	HandlerType: (*NewsFeedServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetFeed",
+			Handler:    _NewsFeed_GetFeed_Handler,
+		},
+		{
+			MethodName: "GetPublication",
+			Handler:    _NewsFeed_GetPublication_Handler,
+		},
+		{
+			MethodName: "AddSource",
+			Handler:    _NewsFeed_AddSource_Handler,
+		},
+		{
+			MethodName: "RemoveSource",
+			Handler:    _NewsFeed_RemoveSource_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "newsfeed.proto",
+}
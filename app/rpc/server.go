@@ -0,0 +1,256 @@
+package rpc
+
+//go:generate protoc -I pb --go_out=pb --go_opt=paths=source_relative --go-grpc_out=pb --go-grpc_opt=paths=source_relative newsfeed.proto
+
+import (
+	"context"
+	"fmt"
+	"github.com/ghosts-network/news-feed/app/rpc/pb"
+	"github.com/ghosts-network/news-feed/news"
+	"github.com/ghosts-network/news-feed/utils/env"
+	"github.com/ghosts-network/news-feed/utils/logger"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"log"
+	"net"
+	"time"
+)
+
+func RunServer(ctx context.Context, newsStorage *news.MongoNewsStorage) {
+	log.SetFlags(0)
+
+	s := grpc.NewServer(grpc.ChainUnaryInterceptor(scopedLoggerInterceptor, loggingInterceptor))
+	pb.RegisterNewsFeedServer(s, &newsFeedServer{newsStorage: newsStorage})
+
+	address := env.String("GRPC_ADDRESS", ":8080")
+	lis, err := net.Listen("tcp", address)
+	if err != nil {
+		logger.Error(err, &map[string]any{})
+		return
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		logger.Info(fmt.Sprintf("Starting grpc server on %s", address), &map[string]any{})
+		errc <- s.Serve(lis)
+	}()
+
+	select {
+	case err := <-errc:
+		logger.Error(errors.Wrap(err, "Grpc server stopped"), &map[string]any{})
+		return
+	case <-ctx.Done():
+	}
+
+	logger.Info("Shutting down grpc server", &map[string]any{})
+
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		logger.Info("Grpc server stopped", &map[string]any{})
+	case <-time.After(env.Duration("GRPC_SHUTDOWN_TIMEOUT", 20*time.Second)):
+		s.Stop()
+		logger.Error(errors.New("Grpc server did not drain in-flight requests in time"), &map[string]any{})
+	}
+}
+
+type newsFeedServer struct {
+	pb.UnimplementedNewsFeedServer
+	newsStorage *news.MongoNewsStorage
+}
+
+func (s *newsFeedServer) GetFeed(ctx context.Context, req *pb.GetFeedRequest) (*pb.GetFeedResponse, error) {
+	if req.User == "" {
+		return nil, status.Error(codes.InvalidArgument, "user is required")
+	}
+
+	take := int(req.Take)
+	if 0 >= take || take > 100 {
+		take = 20
+	}
+
+	ps, err := s.newsStorage.FindNews(ctx, req.User, req.Cursor, take)
+	if err != nil {
+		return nil, toStatus(ctx, errors.Wrap(err, "Failed to fetch news"))
+	}
+
+	resp := &pb.GetFeedResponse{
+		Publications: make([]*pb.Publication, 0, len(ps)),
+	}
+	for i := range ps {
+		resp.Publications = append(resp.Publications, toPublication(&ps[i]))
+	}
+	if len(ps) > 0 {
+		resp.NextCursor = ps[len(ps)-1].Id
+	}
+
+	return resp, nil
+}
+
+func (s *newsFeedServer) GetPublication(ctx context.Context, req *pb.GetPublicationRequest) (*pb.Publication, error) {
+	p, err := s.newsStorage.FindPublication(ctx, req.Id)
+	if err != nil {
+		return nil, toStatus(ctx, errors.Wrap(err, fmt.Sprintf("Failed to fetch publication %s", req.Id)))
+	}
+
+	return toPublication(p), nil
+}
+
+func (s *newsFeedServer) AddSource(ctx context.Context, req *pb.AddSourceRequest) (*pb.AddSourceResponse, error) {
+	if req.User == "" || req.Source == "" {
+		return nil, status.Error(codes.InvalidArgument, "user and source are required")
+	}
+
+	kind := news.FriendSource
+	if req.Kind == pb.SourceKind_SOURCE_KIND_PENDING_REQUEST {
+		kind = news.PendingRequestSource
+	}
+
+	if err := s.newsStorage.AddUserSource(ctx, req.User, req.Source, kind); err != nil {
+		return nil, toStatus(ctx, errors.Wrap(err, fmt.Sprintf("Failed to add source %s for %s", req.Source, req.User)))
+	}
+
+	return &pb.AddSourceResponse{}, nil
+}
+
+func (s *newsFeedServer) RemoveSource(ctx context.Context, req *pb.RemoveSourceRequest) (*pb.RemoveSourceResponse, error) {
+	if req.User == "" || req.Source == "" {
+		return nil, status.Error(codes.InvalidArgument, "user and source are required")
+	}
+
+	if err := s.newsStorage.RemoveUserSource(ctx, req.User, req.Source); err != nil {
+		return nil, toStatus(ctx, errors.Wrap(err, fmt.Sprintf("Failed to remove source %s for %s", req.Source, req.User)))
+	}
+
+	return &pb.RemoveSourceResponse{}, nil
+}
+
+// toStatus maps error the same way problemCode of http api does
+func toStatus(ctx context.Context, err error) error {
+	var netErr net.Error
+	var selectionErr topology.ServerSelectionError
+
+	switch {
+	case errors.Is(err, news.ErrPublicationNotFound), errors.Is(err, news.ErrUnknownUser):
+		return status.Error(codes.NotFound, errors.Cause(err).Error())
+	case errors.Is(err, news.ErrInvalidCursor):
+		return status.Error(codes.InvalidArgument, errors.Cause(err).Error())
+	case errors.Is(err, news.ErrSourceExists):
+		return status.Error(codes.AlreadyExists, errors.Cause(err).Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, context.Canceled.Error())
+	case errors.Is(err, context.DeadlineExceeded), mongo.IsTimeout(err), errors.As(err, &netErr) && netErr.Timeout():
+		return status.Error(codes.DeadlineExceeded, context.DeadlineExceeded.Error())
+	case errors.As(err, &netErr), errors.As(err, &selectionErr), mongo.IsNetworkError(err):
+		logger.Error(err, &map[string]any{
+			"correlationId": ctx.Value("correlationId"),
+		})
+		return status.Error(codes.Unavailable, "storage is unavailable")
+	}
+
+	logger.Error(err, &map[string]any{
+		"correlationId": ctx.Value("correlationId"),
+	})
+	return status.Error(codes.Internal, "internal error")
+}
+
+func toPublication(p *news.Publication) *pb.Publication {
+	var author *pb.PublicationAuthor
+	if p.Author != nil {
+		author = &pb.PublicationAuthor{
+			Id:        p.Author.Id,
+			FullName:  p.Author.FullName,
+			AvatarUrl: p.Author.AvatarUrl,
+		}
+	}
+
+	media := make([]*pb.Media, 0, len(p.Media))
+	for _, m := range p.Media {
+		if m != nil {
+			media = append(media, &pb.Media{Link: m.Link})
+		}
+	}
+
+	var provenance *pb.Provenance
+	if p.Provenance != nil {
+		provenance = &pb.Provenance{Reason: toSourceKind(p.Provenance.Reason), Source: p.Provenance.Source}
+	}
+
+	return &pb.Publication{
+		Id:         p.Id,
+		Content:    p.Content,
+		Author:     author,
+		CreatedOn:  timestamppb.New(p.CreatedOn),
+		UpdatedOn:  timestamppb.New(p.UpdatedOn),
+		Media:      media,
+		Provenance: provenance,
+	}
+}
+
+func toSourceKind(kind news.SourceKind) pb.SourceKind {
+	switch kind {
+	case news.FriendSource:
+		return pb.SourceKind_SOURCE_KIND_FRIEND
+	case news.PendingRequestSource:
+		return pb.SourceKind_SOURCE_KIND_PENDING_REQUEST
+	default:
+		return pb.SourceKind_SOURCE_KIND_UNSPECIFIED
+	}
+}
+
+func scopedLoggerInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	id := metadataValue(ctx, "x-request-id")
+	if id == "" {
+		id = uuid.NewString()
+	}
+	_ = grpc.SetHeader(ctx, metadata.Pairs("x-request-id", id))
+
+	return handler(context.WithValue(ctx, "correlationId", id), req)
+}
+
+func loggingInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	st := time.Now()
+
+	logger.Info(fmt.Sprintf("%s request started", info.FullMethod), &map[string]any{
+		"correlationId": ctx.Value("correlationId"),
+		"callerId":      metadataValue(ctx, "x-caller-id"),
+		"type":          "incoming:grpc",
+	})
+
+	resp, err := handler(ctx, req)
+
+	logger.Info(fmt.Sprintf("%s request finished", info.FullMethod), &map[string]any{
+		"correlationId":       ctx.Value("correlationId"),
+		"callerId":            metadataValue(ctx, "x-caller-id"),
+		"type":                "incoming:grpc",
+		"statusCode":          status.Code(err).String(),
+		"elapsedMilliseconds": time.Now().Sub(st).Milliseconds(),
+	})
+
+	return resp, err
+}
+
+func metadataValue(ctx context.Context, key string) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+
+	return ""
+}
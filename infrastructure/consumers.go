@@ -0,0 +1,154 @@
+package infrastructure
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+const settleTimeout = 10 * time.Second
+
+// consumers tracks consuming goroutines of a bus, handlers get context which is cancelled
+// when consumers don't finish in time on shutdown
+type consumers struct {
+	wg       sync.WaitGroup
+	handlers context.Context
+	abort    context.CancelFunc
+}
+
+func newConsumers() *consumers {
+	handlers, abort := context.WithCancel(context.Background())
+	return &consumers{handlers: handlers, abort: abort}
+}
+
+func (c *consumers) start(consume func()) {
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		consume()
+	}()
+}
+
+// drain waits for consumers to settle in-flight messages, handlers still running when ctx is done are cancelled
+func (c *consumers) drain(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		c.abort()
+	}
+
+	// give aborted handlers a chance to settle their messages
+	select {
+	case <-done:
+	case <-time.After(settleTimeout):
+	}
+
+	return ctx.Err()
+}
+
+// settleContext is used to ack messages after consumption is stopped. Consumption could stop while message is
+// handled, so settlement doesn't use context of the consumer
+func settleContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), settleTimeout)
+}
+
+// ConsumerOptions configures concurrency of a subscription
+type ConsumerOptions struct {
+	// Workers is number of messages handled at the same time
+	Workers int
+	// Prefetch is number of messages received ahead, it is never less than Workers
+	Prefetch int
+	// OrderingKey returns key of the message, messages with the same key are handled in order of delivery.
+	// Messages without key are spread across workers
+	OrderingKey func(message []byte) string
+}
+
+func NewConsumerOptions(workers int, prefetch int, orderingKey func(message []byte) string) ConsumerOptions {
+	if workers <= 0 {
+		workers = 1
+	}
+	if prefetch < workers {
+		prefetch = workers
+	}
+
+	return ConsumerOptions{Workers: workers, Prefetch: prefetch, OrderingKey: orderingKey}
+}
+
+func (o ConsumerOptions) key(message []byte) string {
+	if o.OrderingKey == nil {
+		return ""
+	}
+
+	return o.OrderingKey(message)
+}
+
+// keyedPool runs tasks on a fixed number of workers, tasks with the same key always go to the same worker
+// so they are run in order. Number of queued and running tasks is limited by capacity
+type keyedPool struct {
+	queues []chan func()
+	slots  chan struct{}
+	next   uint32
+	wg     sync.WaitGroup
+}
+
+func newKeyedPool(workers int, capacity int) *keyedPool {
+	p := &keyedPool{
+		queues: make([]chan func(), workers),
+		slots:  make(chan struct{}, capacity),
+	}
+
+	for i := range p.queues {
+		queue := make(chan func(), capacity)
+		p.queues[i] = queue
+
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for task := range queue {
+				task()
+				<-p.slots
+			}
+		}()
+	}
+
+	return p
+}
+
+// dispatch blocks while pool is full, false is returned when ctx is done before task is accepted.
+// It is called by a single consuming goroutine
+func (p *keyedPool) dispatch(ctx context.Context, key string, task func()) bool {
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		return false
+	}
+
+	var i uint32
+	if key == "" {
+		i = p.next % uint32(len(p.queues))
+		p.next++
+	} else {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(key))
+		i = h.Sum32() % uint32(len(p.queues))
+	}
+
+	p.queues[i] <- task
+	return true
+}
+
+// close waits for accepted tasks to finish, dispatch must not be called after close
+func (p *keyedPool) close() {
+	for _, queue := range p.queues {
+		close(queue)
+	}
+	p.wg.Wait()
+}
@@ -3,10 +3,33 @@ package infrastructure
 import (
 	"fmt"
 	"github.com/ghosts-network/news-feed/utils/logger"
+	"github.com/pkg/errors"
 	"net/http"
 	"time"
 )
 
+var ErrNotFound = errors.New("resource not found")
+
+type UpstreamError struct {
+	Url        string
+	StatusCode int
+}
+
+func (e *UpstreamError) Error() string {
+	return fmt.Sprintf("upstream %s responded with status code %d", e.Url, e.StatusCode)
+}
+
+func checkResponse(resp *http.Response) error {
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	case resp.StatusCode >= http.StatusBadRequest:
+		return &UpstreamError{Url: resp.Request.URL.String(), StatusCode: resp.StatusCode}
+	default:
+		return nil
+	}
+}
+
 func NewScopedClient() *http.Client {
 	return &http.Client{
 		Transport: &LogRoundTripper{
@@ -29,6 +52,15 @@ func (t LogRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	})
 
 	resp, err := t.Proxied.RoundTrip(req)
+	if err != nil {
+		logger.Error(errors.Wrap(err, fmt.Sprintf("Outgoing http request failed %s %s", req.Method, req.URL.String())), &map[string]any{
+			"correlationId":       req.Context().Value("correlationId"),
+			"type":                "outgoing:http",
+			"elapsedMilliseconds": time.Now().Sub(st).Milliseconds(),
+		})
+		return resp, err
+	}
+
 	logger.Info(fmt.Sprintf("Outgoing http request finished %s %s", req.Method, req.URL.String()), &map[string]any{
 		"correlationId":       req.Context().Value("correlationId"),
 		"type":                "outgoing:http",
@@ -0,0 +1,223 @@
+package infrastructure
+
+import (
+	"context"
+	"fmt"
+	"github.com/ghosts-network/news-feed/utils/logger"
+	"github.com/pkg/errors"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kafka consumes every topic with its own client, clients of the same subscription join one consumer group
+type Kafka struct {
+	seeds     []string
+	retry     RetryPolicy
+	options   KafkaOptions
+	consumers *consumers
+
+	mu      sync.Mutex
+	clients []*kgo.Client
+}
+
+// KafkaOptions configures clients and dead-lettering
+type KafkaOptions struct {
+	// DeadLetterTopic is a template with {topic} placeholder, empty value drops failed records after they are logged
+	DeadLetterTopic string
+	// DeadLetterBackoff delays producing to dead-letter topic after failure, only delays are used,
+	// producing is retried until partition of the record is revoked
+	DeadLetterBackoff RetryPolicy
+	// ClientOptions are added to options of every client, e.g. to configure TLS or SASL
+	ClientOptions []kgo.Opt
+}
+
+func DefaultKafkaOptions() KafkaOptions {
+	return KafkaOptions{
+		DeadLetterTopic:   "{topic}.dlq",
+		DeadLetterBackoff: NewRetryPolicy(0, time.Second, 30*time.Second),
+	}
+}
+
+func NewKafka(seeds []string, retry RetryPolicy, options KafkaOptions) *Kafka {
+	return &Kafka{seeds: seeds, retry: retry, options: options, consumers: newConsumers()}
+}
+
+// ListenOne consumes topic in consumer group named after subscription until ctx is done.
+// Offsets are committed only after records are handled, unhandled records are delivered again
+func (k *Kafka) ListenOne(ctx context.Context, topicName string, subscriptionName string, handler func(context.Context, []byte) error, options ConsumerOptions) error {
+	partitions := newKafkaPartitions(ctx, topicName)
+
+	opts := []kgo.Opt{
+		kgo.SeedBrokers(k.seeds...),
+		kgo.ConsumerGroup(subscriptionName),
+		kgo.ConsumeTopics(topicName),
+		kgo.AutoCommitMarks(),
+		kgo.OnPartitionsAssigned(partitions.assigned),
+		kgo.OnPartitionsRevoked(partitions.revoked),
+		kgo.OnPartitionsLost(partitions.lost),
+	}
+
+	client, err := kgo.NewClient(append(opts, k.options.ClientOptions...)...)
+	if err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	k.clients = append(k.clients, client)
+	k.mu.Unlock()
+
+	k.consumers.start(func() {
+		pool := newKeyedPool(options.Workers, options.Prefetch)
+		defer pool.close()
+
+		for ctx.Err() == nil {
+			fetches := client.PollRecords(ctx, options.Prefetch)
+			if fetches.IsClientClosed() {
+				return
+			}
+
+			// client retries failed fetches itself, errors are only reported
+			for _, fetchErr := range fetches.Errors() {
+				if ctx.Err() == nil {
+					logger.Error(errors.Wrap(fetchErr.Err, fmt.Sprintf("Failed to fetch records of %s", topicName)), &map[string]any{
+						"topic":     topicName,
+						"partition": fetchErr.Partition,
+					})
+				}
+			}
+
+			iter := fetches.RecordIter()
+			for !iter.Done() {
+				record := iter.Next()
+				p, tracked, ok := partitions.track(record)
+				if !ok {
+					continue
+				}
+
+				// records without ordering key keep order of their partition
+				key := options.key(record.Value)
+				if key == "" {
+					key = strconv.Itoa(int(record.Partition))
+				}
+
+				if !pool.dispatch(ctx, key, func() { partitions.settle(client, p, tracked, k.handle(p.ctx, client, topicName, record, handler)) }) {
+					partitions.settle(client, p, tracked, false)
+					return
+				}
+			}
+		}
+	})
+
+	return nil
+}
+
+// handle returns false when record is not handled and has to be delivered again,
+// it only happens when partition is revoked or consumption is stopped
+func (k *Kafka) handle(ctx context.Context, client *kgo.Client, topicName string, record *kgo.Record, handler func(context.Context, []byte) error) bool {
+	// partition was revoked or consumption stopped while record was waiting for a worker
+	if ctx.Err() != nil {
+		return false
+	}
+
+	st := time.Now()
+
+	correlationId := recordHeader(record, "correlationId")
+	messageId := recordHeader(record, "messageId")
+	if messageId == "" {
+		messageId = fmt.Sprintf("%d/%d", record.Partition, record.Offset)
+	}
+
+	scope := map[string]any{
+		"correlationId": correlationId,
+		"type":          "incoming:kafka",
+		"messageId":     messageId,
+		"topic":         topicName,
+	}
+
+	logger.Info(fmt.Sprintf("Message %s processing started", messageId), &scope)
+	handlerCtx := context.WithValue(k.consumers.handlers, "correlationId", correlationId)
+	handlerCtx = context.WithValue(handlerCtx, "messageId", messageId)
+	result, err := k.retry.handle(ctx, handlerCtx, handler, record.Value, scope)
+	scope["elapsedMilliseconds"] = time.Now().Sub(st).Milliseconds()
+
+	switch result {
+	case completed:
+		logger.Info(fmt.Sprintf("Message %s finished", messageId), &scope)
+		return true
+	case abandoned:
+		logger.Error(errors.Wrap(err, fmt.Sprintf("Message %s abandoned", messageId)), &scope)
+		return false
+	}
+
+	if k.options.DeadLetterTopic == "" {
+		logger.Error(errors.Wrap(err, fmt.Sprintf("Message %s dropped", messageId)), &scope)
+		return true
+	}
+
+	// record can't be skipped until it is dead-lettered, next records of the partition are not committed meanwhile
+	for attempt := 1; ; attempt++ {
+		dlqErr := k.deadLetter(client, topicName, record, err)
+		if dlqErr == nil {
+			break
+		}
+
+		delay := k.options.DeadLetterBackoff.delay(attempt + 1)
+		logger.Error(errors.Wrap(dlqErr, fmt.Sprintf("Failed to dead-letter message %s, retrying in %s", messageId, delay)), &scope)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	logger.Error(errors.Wrap(err, fmt.Sprintf("Message %s dead-lettered", messageId)), &scope)
+	return true
+}
+
+// deadLetter produces copy of the record with failure description to the dead-letter topic
+func (k *Kafka) deadLetter(client *kgo.Client, topicName string, record *kgo.Record, err error) error {
+	ctx, cancel := settleContext()
+	defer cancel()
+
+	headers := append([]kgo.RecordHeader(nil), record.Headers...)
+	headers = append(headers,
+		kgo.RecordHeader{Key: "deadLetterReason", Value: []byte(deadLetterReason(err))},
+		kgo.RecordHeader{Key: "deadLetterErrorDescription", Value: []byte(err.Error())})
+
+	return client.ProduceSync(ctx, &kgo.Record{
+		Topic:   strings.ReplaceAll(k.options.DeadLetterTopic, "{topic}", topicName),
+		Key:     record.Key,
+		Value:   record.Value,
+		Headers: headers,
+	}).FirstErr()
+}
+
+func recordHeader(record *kgo.Record, key string) string {
+	for _, header := range record.Headers {
+		if header.Key == key {
+			return string(header.Value)
+		}
+	}
+
+	return ""
+}
+
+// Close waits for in-flight records to be handled until ctx is done, commits their offsets and leaves consumer groups
+func (k *Kafka) Close(ctx context.Context) error {
+	err := k.consumers.drain(ctx)
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	// leaving group revokes partitions, offsets are committed on revoke
+	for _, client := range k.clients {
+		client.Close()
+	}
+	k.clients = nil
+
+	return err
+}
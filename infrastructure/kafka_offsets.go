@@ -0,0 +1,154 @@
+package infrastructure
+
+import (
+	"context"
+	"github.com/ghosts-network/news-feed/utils/logger"
+	"github.com/pkg/errors"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"sync"
+	"time"
+)
+
+// kafkaPartitions tracks records of partitions assigned to the client. Records of a partition may be handled
+// out of order by different workers, so offset is marked for commit only when every record before it is handled
+type kafkaPartitions struct {
+	ctx   context.Context
+	topic string
+
+	mu         sync.Mutex
+	partitions map[int32]*kafkaPartition
+}
+
+type kafkaPartition struct {
+	// ctx is cancelled when partition is revoked, records which are not handled by then are left for the next owner
+	ctx      context.Context
+	cancel   context.CancelFunc
+	inflight sync.WaitGroup
+	// records in order of offsets, handled records are removed from the front
+	records []*kafkaRecord
+}
+
+type kafkaRecord struct {
+	record  *kgo.Record
+	handled bool
+}
+
+func newKafkaPartitions(ctx context.Context, topic string) *kafkaPartitions {
+	return &kafkaPartitions{ctx: ctx, topic: topic, partitions: make(map[int32]*kafkaPartition)}
+}
+
+func (ps *kafkaPartitions) assigned(_ context.Context, _ *kgo.Client, assigned map[string][]int32) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	for _, id := range assigned[ps.topic] {
+		if _, ok := ps.partitions[id]; ok {
+			continue
+		}
+
+		ctx, cancel := context.WithCancel(ps.ctx)
+		ps.partitions[id] = &kafkaPartition{ctx: ctx, cancel: cancel}
+	}
+}
+
+// revoked gives handlers of revoked partitions a chance to finish and commits their offsets before partitions are reassigned
+func (ps *kafkaPartitions) revoked(_ context.Context, client *kgo.Client, revoked map[string][]int32) {
+	partitions := ps.cancel(revoked[ps.topic])
+
+	done := make(chan struct{})
+	go func() {
+		for _, p := range partitions {
+			p.inflight.Wait()
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(settleTimeout):
+	}
+
+	ps.remove(revoked[ps.topic])
+
+	commitCtx, cancel := settleContext()
+	defer cancel()
+
+	if err := client.CommitMarkedOffsets(commitCtx); err != nil {
+		logger.Error(errors.Wrap(err, "Failed to commit offsets of revoked partitions"), &map[string]any{
+			"topic": ps.topic,
+		})
+	}
+}
+
+// lost drops partitions without commit, they are already owned by another member
+func (ps *kafkaPartitions) lost(_ context.Context, _ *kgo.Client, lost map[string][]int32) {
+	ps.cancel(lost[ps.topic])
+	ps.remove(lost[ps.topic])
+}
+
+// cancel stops tracking of new records of the partitions, records which are already tracked are still settled
+func (ps *kafkaPartitions) cancel(ids []int32) []*kafkaPartition {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	partitions := make([]*kafkaPartition, 0, len(ids))
+	for _, id := range ids {
+		if p, ok := ps.partitions[id]; ok {
+			p.cancel()
+			partitions = append(partitions, p)
+		}
+	}
+
+	return partitions
+}
+
+func (ps *kafkaPartitions) remove(ids []int32) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	for _, id := range ids {
+		delete(ps.partitions, id)
+	}
+}
+
+// track registers polled record, false is returned when its partition is no longer assigned
+func (ps *kafkaPartitions) track(record *kgo.Record) (*kafkaPartition, *kafkaRecord, bool) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	p, ok := ps.partitions[record.Partition]
+	if !ok || p.ctx.Err() != nil {
+		return nil, nil, false
+	}
+
+	tracked := &kafkaRecord{record: record}
+	p.records = append(p.records, tracked)
+	p.inflight.Add(1)
+
+	return p, tracked, true
+}
+
+// settle marks offset of the last record handled together with every record before it.
+// Records are left unhandled only when partition is revoked, next owner consumes them from the last committed offset
+func (ps *kafkaPartitions) settle(client *kgo.Client, p *kafkaPartition, tracked *kafkaRecord, handled bool) {
+	defer p.inflight.Done()
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	tracked.handled = handled
+	// partition was revoked while record was handled
+	if ps.partitions[tracked.record.Partition] != p {
+		return
+	}
+
+	var last *kgo.Record
+	for len(p.records) > 0 && p.records[0].handled {
+		last = p.records[0].record
+		p.records = p.records[1:]
+	}
+
+	if last != nil {
+		client.MarkCommitRecords(last)
+	}
+}
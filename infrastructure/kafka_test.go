@@ -0,0 +1,297 @@
+package infrastructure
+
+import (
+	"context"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+	"sync"
+	"testing"
+	"time"
+)
+
+type permanentError struct{}
+
+func (permanentError) Error() string   { return "malformed message" }
+func (permanentError) Permanent() bool { return true }
+
+// recorded collects bodies of handled messages
+type recorded struct {
+	mu     sync.Mutex
+	bodies []string
+}
+
+func (r *recorded) add(body []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.bodies = append(r.bodies, string(body))
+}
+
+func (r *recorded) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]string(nil), r.bodies...)
+}
+
+// eventually fails test when condition is not met in 10 seconds
+func eventually(t *testing.T, condition func() bool, message string) {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func newKafkaCluster(t *testing.T, partitions int32, topics ...string) []string {
+	t.Helper()
+
+	cluster, err := kfake.NewCluster(kfake.NumBrokers(1), kfake.SeedTopics(partitions, topics...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cluster.Close)
+
+	return cluster.ListenAddrs()
+}
+
+func produceKafka(t *testing.T, seeds []string, topic string, values ...string) {
+	t.Helper()
+
+	client, err := kgo.NewClient(kgo.SeedBrokers(seeds...), kgo.RecordPartitioner(kgo.RoundRobinPartitioner()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	for _, value := range values {
+		if err := client.ProduceSync(context.Background(), &kgo.Record{Topic: topic, Value: []byte(value)}).FirstErr(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// consumeKafka reads records of the topic from the start until count records are read
+func consumeKafka(t *testing.T, seeds []string, topic string, count int) []*kgo.Record {
+	t.Helper()
+
+	client, err := kgo.NewClient(kgo.SeedBrokers(seeds...), kgo.ConsumeTopics(topic))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var records []*kgo.Record
+	for len(records) < count {
+		fetches := client.PollFetches(ctx)
+		if ctx.Err() != nil {
+			t.Fatalf("read %d records of %s, expected %d", len(records), topic, count)
+		}
+		records = append(records, fetches.Records()...)
+	}
+
+	return records
+}
+
+// committed returns offset committed by the first consumer of the topic, -1 before anything is committed
+func committed(k *Kafka, topic string) int64 {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if offset, ok := k.clients[0].CommittedOffsets()[topic][0]; ok {
+		return offset.Offset
+	}
+
+	return -1
+}
+
+func listenKafka(t *testing.T, seeds []string, options KafkaOptions, handler func(context.Context, []byte) error) (*Kafka, context.CancelFunc) {
+	t.Helper()
+
+	options.ClientOptions = append(options.ClientOptions, kgo.AutoCommitInterval(100*time.Millisecond), kgo.FetchMaxWait(100*time.Millisecond))
+	k := NewKafka(seeds, NewRetryPolicy(1, 0, 0), options)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := k.ListenOne(ctx, "t", "newsfeed", handler, NewConsumerOptions(1, 10, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		cancel()
+		_ = k.Close(context.Background())
+	})
+
+	return k, cancel
+}
+
+// dlqFailures reports records which failed to be produced
+type dlqFailures chan error
+
+func (f dlqFailures) OnProduceRecordUnbuffered(_ *kgo.Record, err error) {
+	if err != nil {
+		select {
+		case f <- err:
+		default:
+		}
+	}
+}
+
+func TestKafka_RetriesDeadLetterUntilProduced(t *testing.T) {
+	seeds := newKafkaCluster(t, 1, "t")
+	produceKafka(t, seeds, "t", "bad", "good")
+
+	failures := make(dlqFailures, 1)
+	handled := &recorded{}
+	k, _ := listenKafka(t, seeds, KafkaOptions{
+		DeadLetterTopic:   "{topic}.dlq",
+		DeadLetterBackoff: NewRetryPolicy(0, 50*time.Millisecond, 50*time.Millisecond),
+		ClientOptions:     []kgo.Opt{kgo.UnknownTopicRetries(0), kgo.WithHooks(failures)},
+	}, func(ctx context.Context, body []byte) error {
+		handled.add(body)
+		if string(body) == "bad" {
+			return permanentError{}
+		}
+		return nil
+	})
+
+	select {
+	case <-failures:
+	case <-time.After(10 * time.Second):
+		t.Fatal("dead-lettering didn't fail")
+	}
+
+	// record which is not dead-lettered holds back commits and records after it
+	time.Sleep(200 * time.Millisecond)
+	if offset := committed(k, "t"); offset > 0 {
+		t.Fatalf("offset %d is committed before record is dead-lettered", offset)
+	}
+	if bodies := handled.get(); len(bodies) != 1 {
+		t.Fatalf("handled %v, expected only failed record", bodies)
+	}
+
+	admin, err := kgo.NewClient(kgo.SeedBrokers(seeds...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+
+	req := kmsg.NewPtrCreateTopicsRequest()
+	topic := kmsg.NewCreateTopicsRequestTopic()
+	topic.Topic, topic.NumPartitions, topic.ReplicationFactor = "t.dlq", 1, 1
+	req.Topics = append(req.Topics, topic)
+	if _, err := req.RequestWith(context.Background(), admin); err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, func() bool { return committed(k, "t") == 2 }, "offsets are not committed after record is dead-lettered")
+
+	records := consumeKafka(t, seeds, "t.dlq", 1)
+	if string(records[0].Value) != "bad" {
+		t.Errorf("dead-lettered %q, expected %q", records[0].Value, "bad")
+	}
+}
+
+func TestKafka_ConsumerGroupHandlesEveryRecordOnce(t *testing.T) {
+	seeds := newKafkaCluster(t, 4, "t")
+
+	handled := &recorded{}
+	handler := func(ctx context.Context, body []byte) error {
+		handled.add(body)
+		return nil
+	}
+
+	// second member joins while first one consumes, partitions are shared between them
+	listenKafka(t, seeds, DefaultKafkaOptions(), handler)
+	produceKafka(t, seeds, "t", "1", "2", "3", "4", "5", "6", "7", "8")
+	listenKafka(t, seeds, DefaultKafkaOptions(), handler)
+	produceKafka(t, seeds, "t", "9", "10", "11", "12", "13", "14", "15", "16")
+
+	eventually(t, func() bool { return len(handled.get()) >= 16 }, "records are not handled")
+	time.Sleep(300 * time.Millisecond)
+
+	seen := make(map[string]bool)
+	for _, body := range handled.get() {
+		if seen[body] {
+			t.Errorf("record %s is handled more than once", body)
+		}
+		seen[body] = true
+	}
+	if len(seen) != 16 {
+		t.Errorf("handled %d records, expected 16", len(seen))
+	}
+}
+
+func TestKafka_CommitsOffsetsOfHandledRecords(t *testing.T) {
+	seeds := newKafkaCluster(t, 1, "t")
+	produceKafka(t, seeds, "t", "a", "b", "c")
+
+	started := make(chan struct{})
+	k, cancel := listenKafka(t, seeds, DefaultKafkaOptions(), func(ctx context.Context, body []byte) error {
+		if string(body) == "b" {
+			close(started)
+			// handler is cancelled when shutdown times out
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	})
+
+	<-started
+	eventually(t, func() bool { return committed(k, "t") == 1 }, "offset of handled record is not committed")
+
+	cancel()
+	closeCtx, closeCancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer closeCancel()
+	_ = k.Close(closeCtx)
+
+	// next member of the group continues from the first record which was not handled
+	handled := &recorded{}
+	listenKafka(t, seeds, DefaultKafkaOptions(), func(ctx context.Context, body []byte) error {
+		handled.add(body)
+		return nil
+	})
+
+	eventually(t, func() bool { return len(handled.get()) >= 2 }, "unhandled records are not delivered again")
+	time.Sleep(300 * time.Millisecond)
+	if bodies := handled.get(); len(bodies) != 2 || bodies[0] != "b" || bodies[1] != "c" {
+		t.Errorf("handled %v, expected [b c]", bodies)
+	}
+}
+
+func TestKafka_DeadLettersFailedRecords(t *testing.T) {
+	seeds := newKafkaCluster(t, 1, "t", "t.dlq")
+	produceKafka(t, seeds, "t", "bad", "good")
+
+	handled := &recorded{}
+	k, _ := listenKafka(t, seeds, DefaultKafkaOptions(), func(ctx context.Context, body []byte) error {
+		handled.add(body)
+		if string(body) == "bad" {
+			return permanentError{}
+		}
+		return nil
+	})
+
+	eventually(t, func() bool { return committed(k, "t") == 2 }, "offsets of handled and dead-lettered records are not committed")
+
+	record := consumeKafka(t, seeds, "t.dlq", 1)[0]
+	if string(record.Value) != "bad" {
+		t.Errorf("dead-lettered %q, expected %q", record.Value, "bad")
+	}
+	if reason := recordHeader(record, "deadLetterReason"); reason != "MessageRejected" {
+		t.Errorf("dead-letter reason is %q, expected %q", reason, "MessageRejected")
+	}
+	if description := recordHeader(record, "deadLetterErrorDescription"); description != "malformed message" {
+		t.Errorf("dead-letter description is %q, expected %q", description, "malformed message")
+	}
+	if bodies := handled.get(); len(bodies) != 2 {
+		t.Errorf("handled %v, expected both records", bodies)
+	}
+}
@@ -0,0 +1,238 @@
+package infrastructure
+
+import (
+	"context"
+	"fmt"
+	"github.com/ghosts-network/news-feed/utils/logger"
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+	"strings"
+	"time"
+)
+
+// NatsJetStream consumes topics as subjects of existing streams with durable pull consumers
+type NatsJetStream struct {
+	conn      *nats.Conn
+	js        nats.JetStreamContext
+	retry     RetryPolicy
+	options   NatsOptions
+	consumers *consumers
+}
+
+// NatsOptions configures durable consumers and fetching of messages
+type NatsOptions struct {
+	// AckWait is time server waits for ack before message is delivered again, progress is reported while message is handled
+	AckWait time.Duration
+	// MaxDeliver limits deliveries of a message by the server, zero value doesn't limit them
+	MaxDeliver int
+	// BackOff delays redeliveries of messages which are not acknowledged, it must not be longer than MaxDeliver
+	BackOff []time.Duration
+	// FetchWait limits how long pull request waits for messages, messages abandoned on shutdown are delivered again after it
+	FetchWait time.Duration
+	// FetchBackoff delays fetching after failure, only delays are used, fetching is retried until ctx is done
+	FetchBackoff RetryPolicy
+}
+
+func DefaultNatsOptions() NatsOptions {
+	return NatsOptions{
+		AckWait:      30 * time.Second,
+		FetchWait:    5 * time.Second,
+		FetchBackoff: NewRetryPolicy(0, time.Second, 30*time.Second),
+	}
+}
+
+func NewNatsJetStream(conn *nats.Conn, retry RetryPolicy, options NatsOptions) (*NatsJetStream, error) {
+	js, err := conn.JetStream()
+	if err != nil {
+		return nil, err
+	}
+	if options.FetchWait <= 0 {
+		options.FetchWait = DefaultNatsOptions().FetchWait
+	}
+
+	return &NatsJetStream{conn: conn, js: js, retry: retry, options: options, consumers: newConsumers()}, nil
+}
+
+// Health returns error while connection to the server is being restored
+func (n *NatsJetStream) Health() error {
+	if status := n.conn.Status(); status != nats.CONNECTED {
+		return errors.Errorf("connection is %s", status)
+	}
+
+	return nil
+}
+
+// ListenOne fetches messages of the topic with durable consumer named after subscription until ctx is done
+func (n *NatsJetStream) ListenOne(ctx context.Context, topicName string, subscriptionName string, handler func(context.Context, []byte) error, options ConsumerOptions) error {
+	opts := []nats.SubOpt{
+		nats.AckExplicit(),
+		nats.AckWait(n.options.AckWait),
+		nats.MaxAckPending(options.Prefetch),
+	}
+	if n.options.MaxDeliver > 0 {
+		opts = append(opts, nats.MaxDeliver(n.options.MaxDeliver))
+	}
+	if len(n.options.BackOff) > 0 {
+		opts = append(opts, nats.BackOff(n.options.BackOff))
+	}
+
+	subscription, err := n.js.PullSubscribe(topicName, durableName(subscriptionName, topicName), opts...)
+	if err != nil {
+		return err
+	}
+
+	n.consumers.start(func() {
+		pool := newKeyedPool(options.Workers, options.Prefetch)
+		defer pool.close()
+
+		failures := 0
+		for ctx.Err() == nil {
+			fetchCtx, cancel := context.WithTimeout(ctx, n.options.FetchWait)
+			messages, err := subscription.Fetch(options.Prefetch, nats.Context(fetchCtx))
+			cancel()
+			if err != nil && !isFetchTimeout(err) {
+				if ctx.Err() != nil {
+					return
+				}
+
+				failures++
+				delay := n.options.FetchBackoff.delay(failures + 1)
+				logger.Error(errors.Wrap(err, fmt.Sprintf("Failed to fetch messages of %s, retrying in %s", topicName, delay)), &map[string]any{
+					"topic": topicName,
+				})
+
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+				}
+				continue
+			}
+			failures = 0
+
+			for _, message := range messages {
+				message := message
+				stopProgress := n.reportProgress(message)
+				if !pool.dispatch(ctx, options.key(message.Data), func() { n.handle(ctx, topicName, message, handler, stopProgress) }) {
+					stopProgress()
+					n.abandon(message)
+				}
+			}
+		}
+	})
+
+	return nil
+}
+
+// reportProgress keeps message from being redelivered while it waits for a worker and is handled, returned func stops reporting
+func (n *NatsJetStream) reportProgress(message *nats.Msg) func() {
+	interval := n.options.AckWait
+	if interval <= 0 {
+		// default ack wait of the server
+		interval = 30 * time.Second
+	}
+	if len(n.options.BackOff) > 0 && n.options.BackOff[0] < interval {
+		// server uses backoff as ack wait when it is set
+		interval = n.options.BackOff[0]
+	}
+	interval /= 2
+
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				_ = message.InProgress()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+func (n *NatsJetStream) handle(ctx context.Context, topicName string, message *nats.Msg, handler func(context.Context, []byte) error, stopProgress func()) {
+	if ctx.Err() != nil {
+		stopProgress()
+		n.abandon(message)
+		return
+	}
+
+	st := time.Now()
+
+	correlationId := message.Header.Get("correlationId")
+	messageId := message.Header.Get(nats.MsgIdHdr)
+	if messageId == "" {
+		if meta, err := message.Metadata(); err == nil {
+			messageId = fmt.Sprintf("%s/%d", meta.Stream, meta.Sequence.Stream)
+		}
+	}
+
+	scope := map[string]any{
+		"correlationId": correlationId,
+		"type":          "incoming:nats",
+		"messageId":     messageId,
+		"topic":         topicName,
+	}
+
+	logger.Info(fmt.Sprintf("Message %s processing started", messageId), &scope)
+	handlerCtx := context.WithValue(n.consumers.handlers, "correlationId", correlationId)
+	handlerCtx = context.WithValue(handlerCtx, "messageId", messageId)
+	result, err := n.retry.handle(ctx, handlerCtx, handler, message.Data, scope)
+	scope["elapsedMilliseconds"] = time.Now().Sub(st).Milliseconds()
+
+	stopProgress()
+
+	settleCtx, cancel := settleContext()
+	defer cancel()
+
+	switch result {
+	case completed:
+		if ackErr := message.AckSync(nats.Context(settleCtx)); ackErr != nil {
+			logger.Error(errors.Wrap(ackErr, fmt.Sprintf("Failed to acknowledge message %s", messageId)), &scope)
+			return
+		}
+		logger.Info(fmt.Sprintf("Message %s finished", messageId), &scope)
+	case abandoned:
+		n.abandon(message)
+		logger.Error(errors.Wrap(err, fmt.Sprintf("Message %s abandoned", messageId)), &scope)
+	case deadLettered:
+		// terminated message is reported by the server with MSG_TERMINATED advisory
+		_ = message.Term()
+		logger.Error(errors.Wrap(err, fmt.Sprintf("Message %s dead-lettered", messageId)), &scope)
+	}
+}
+
+// abandon returns message when consumption is stopped. Pull requests of the stopped consumer wait for messages
+// until fetch wait passes, so redelivery is delayed for that time, otherwise message could be delivered to them
+// and it would wait for ack wait
+func (n *NatsJetStream) abandon(message *nats.Msg) {
+	_ = message.NakWithDelay(n.options.FetchWait)
+}
+
+// Close waits for in-flight messages to be settled until ctx is done and closes connection.
+// Subscriptions are not unsubscribed, it would delete durable consumers
+func (n *NatsJetStream) Close(ctx context.Context) error {
+	err := n.consumers.drain(ctx)
+	n.conn.Close()
+
+	return err
+}
+
+// durableName makes name of the consumer from subscription and topic, dots are not allowed in durable names
+func durableName(subscriptionName string, topicName string) string {
+	return strings.NewReplacer(".", "_", "*", "_", ">", "_").Replace(subscriptionName + "-" + topicName)
+}
+
+// isFetchTimeout reports whether fetch finished without messages
+func isFetchTimeout(err error) bool {
+	return errors.Is(err, nats.ErrTimeout) || errors.Is(err, context.DeadlineExceeded)
+}
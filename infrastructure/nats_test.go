@@ -0,0 +1,251 @@
+package infrastructure
+
+import (
+	"context"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"testing"
+	"time"
+)
+
+const natsTopic = "ghostnetwork.content.publications.created"
+
+// newNatsServer starts in-process server with stream of content topics, it is stopped when test is finished
+func newNatsServer(t *testing.T) string {
+	t.Helper()
+
+	s, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, JetStream: true, StoreDir: t.TempDir(), NoLog: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	t.Cleanup(s.Shutdown)
+
+	if !s.ReadyForConnections(10 * time.Second) {
+		t.Fatal("nats server is not ready")
+	}
+
+	js := natsContext(t, s.ClientURL())
+	if _, err := js.AddStream(&nats.StreamConfig{Name: "content", Subjects: []string{"ghostnetwork.content.>"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	return s.ClientURL()
+}
+
+func natsContext(t *testing.T, url string) nats.JetStreamContext {
+	t.Helper()
+
+	conn, err := nats.Connect(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(conn.Close)
+
+	js, err := conn.JetStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return js
+}
+
+func publishNats(t *testing.T, url string, values ...string) {
+	t.Helper()
+
+	js := natsContext(t, url)
+	for _, value := range values {
+		if _, err := js.Publish(natsTopic, []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// consumerInfo returns state of the durable consumer of the listener
+func consumerInfo(t *testing.T, url string) *nats.ConsumerInfo {
+	t.Helper()
+
+	info, err := natsContext(t, url).ConsumerInfo("content", durableName("newsfeed", natsTopic))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return info
+}
+
+func listenNats(t *testing.T, url string, options NatsOptions, handler func(context.Context, []byte) error) (*NatsJetStream, context.CancelFunc) {
+	t.Helper()
+
+	conn, err := nats.Connect(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options.FetchWait = 200 * time.Millisecond
+	options.FetchBackoff = NewRetryPolicy(0, 10*time.Millisecond, 10*time.Millisecond)
+	n, err := NewNatsJetStream(conn, NewRetryPolicy(1, 0, 0), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := n.ListenOne(ctx, natsTopic, "newsfeed", handler, NewConsumerOptions(1, 10, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		cancel()
+		_ = n.Close(context.Background())
+	})
+
+	return n, cancel
+}
+
+// stopNats stops listener while its handlers are running, running handlers are cancelled
+func stopNats(n *NatsJetStream, cancel context.CancelFunc) {
+	cancel()
+
+	ctx, closeCancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer closeCancel()
+
+	_ = n.Close(ctx)
+}
+
+// blockUntilCancelled handles messages until message with given body, which is handled until handler is cancelled
+func blockUntilCancelled(handled *recorded, blocked string, started chan struct{}) func(context.Context, []byte) error {
+	return func(ctx context.Context, body []byte) error {
+		handled.add(body)
+		if string(body) != blocked {
+			return nil
+		}
+
+		started <- struct{}{}
+		<-ctx.Done()
+		return ctx.Err()
+	}
+}
+
+func TestNatsJetStream_DurableConsumerResumes(t *testing.T) {
+	url := newNatsServer(t)
+	publishNats(t, url, "1", "2")
+
+	first := &recorded{}
+	n, cancel := listenNats(t, url, DefaultNatsOptions(), func(ctx context.Context, body []byte) error {
+		first.add(body)
+		return nil
+	})
+
+	eventually(t, func() bool { return consumerInfo(t, url).AckFloor.Stream == 2 }, "handled messages are not acknowledged")
+	cancel()
+	if err := n.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// messages published while nobody listens are kept for the durable consumer
+	publishNats(t, url, "3", "4")
+
+	second := &recorded{}
+	listenNats(t, url, DefaultNatsOptions(), func(ctx context.Context, body []byte) error {
+		second.add(body)
+		return nil
+	})
+
+	eventually(t, func() bool { return len(second.get()) >= 2 }, "consumer doesn't resume")
+	time.Sleep(200 * time.Millisecond)
+
+	if bodies := first.get(); len(bodies) != 2 || bodies[0] != "1" || bodies[1] != "2" {
+		t.Errorf("first listener handled %v, expected [1 2]", bodies)
+	}
+	if bodies := second.get(); len(bodies) != 2 || bodies[0] != "3" || bodies[1] != "4" {
+		t.Errorf("second listener handled %v, expected [3 4]", bodies)
+	}
+}
+
+func TestNatsJetStream_AcksHandledAndTerminatesRejectedMessages(t *testing.T) {
+	url := newNatsServer(t)
+	publishNats(t, url, "bad", "good")
+
+	options := DefaultNatsOptions()
+	options.AckWait = 200 * time.Millisecond
+
+	handled := &recorded{}
+	listenNats(t, url, options, func(ctx context.Context, body []byte) error {
+		handled.add(body)
+		if string(body) == "bad" {
+			return permanentError{}
+		}
+		return nil
+	})
+
+	eventually(t, func() bool { return consumerInfo(t, url).AckFloor.Stream == 2 }, "messages are not settled")
+
+	// terminated message is not delivered again after ack wait
+	time.Sleep(3 * options.AckWait)
+
+	info := consumerInfo(t, url)
+	if info.NumAckPending != 0 || info.NumRedelivered != 0 {
+		t.Errorf("consumer has %d pending and %d redelivered messages, expected none", info.NumAckPending, info.NumRedelivered)
+	}
+	if bodies := handled.get(); len(bodies) != 2 {
+		t.Errorf("handled %v, expected each message once", bodies)
+	}
+}
+
+func TestNatsJetStream_NaksMessagesAbandonedOnShutdown(t *testing.T) {
+	url := newNatsServer(t)
+	publishNats(t, url, "1", "2")
+
+	started := make(chan struct{}, 1)
+	first := &recorded{}
+	n, cancel := listenNats(t, url, DefaultNatsOptions(), blockUntilCancelled(first, "2", started))
+
+	<-started
+	stopNats(n, cancel)
+
+	// message is delivered again after fetch wait, without waiting for 30s ack wait
+	second := &recorded{}
+	listenNats(t, url, DefaultNatsOptions(), func(ctx context.Context, body []byte) error {
+		second.add(body)
+		return nil
+	})
+
+	eventually(t, func() bool { return len(second.get()) >= 1 }, "abandoned message is not delivered again")
+	if bodies := second.get(); len(bodies) != 1 || bodies[0] != "2" {
+		t.Errorf("second listener handled %v, expected [2]", bodies)
+	}
+}
+
+func TestNatsJetStream_LimitsDeliveries(t *testing.T) {
+	url := newNatsServer(t)
+	publishNats(t, url, "1")
+
+	options := DefaultNatsOptions()
+	options.MaxDeliver = 2
+
+	// every listener abandons the message, server stops delivering it after MaxDeliver
+	for i := 0; i < options.MaxDeliver; i++ {
+		started := make(chan struct{}, 1)
+		n, cancel := listenNats(t, url, options, blockUntilCancelled(&recorded{}, "1", started))
+
+		select {
+		case <-started:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("delivery %d of the message didn't happen", i+1)
+		}
+		stopNats(n, cancel)
+	}
+
+	publishNats(t, url, "2")
+
+	handled := &recorded{}
+	listenNats(t, url, options, func(ctx context.Context, body []byte) error {
+		handled.add(body)
+		return nil
+	})
+
+	eventually(t, func() bool { return len(handled.get()) >= 1 }, "next message is not delivered")
+	time.Sleep(200 * time.Millisecond)
+	if bodies := handled.get(); len(bodies) != 1 || bodies[0] != "2" {
+		t.Errorf("handled %v, expected [2]", bodies)
+	}
+}
@@ -34,6 +34,10 @@ func (c ProfilesClient) GetProfiles(ctx context.Context, skip int, take int) ([]
 	}
 	defer resp.Body.Close()
 
+	if err := checkResponse(resp); err != nil {
+		return nil, err
+	}
+
 	rb, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
@@ -45,6 +49,35 @@ func (c ProfilesClient) GetProfiles(ctx context.Context, skip int, take int) ([]
 	return ps, err
 }
 
+func (c ProfilesClient) GetProfile(ctx context.Context, id string) (*Profile, error) {
+	url := fmt.Sprintf("%s/profiles/%s", c.baseUrl, id)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Do(req)
+
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp); err != nil {
+		return nil, err
+	}
+
+	rb, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var p Profile
+	err = json.Unmarshal(rb, &p)
+
+	return &p, err
+}
+
 type Profile struct {
 	Id        string `json:"id"`
 	FirstName string `json:"firstName"`
@@ -35,6 +35,10 @@ func (c PublicationsClient) GetPublications(ctx context.Context, cursor string,
 	}
 	defer resp.Body.Close()
 
+	if err := checkResponse(resp); err != nil {
+		return nil, "", err
+	}
+
 	rb, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, "", err
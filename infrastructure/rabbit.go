@@ -6,67 +6,347 @@ import (
 	"github.com/ghosts-network/news-feed/utils/logger"
 	"github.com/pkg/errors"
 	amqp "github.com/rabbitmq/amqp091-go"
+	"sync"
 	"time"
 )
 
+// RabbitMq owns connection to the broker, lost connection is dialed again with backoff
+// and every subscription is restored on the new connection
 type RabbitMq struct {
-	client *amqp.Connection
-}
+	url       string
+	topology  RabbitTopology
+	retry     RetryPolicy
+	reconnect RetryPolicy
+	consumers *consumers
 
-func NewRabbitMq(client *amqp.Connection) *RabbitMq {
-	return &RabbitMq{client: client}
+	mu   sync.Mutex
+	conn *amqp.Connection
+	// connected is closed while conn is open
+	connected chan struct{}
+	lastErr   error
+	closed    bool
+	done      chan struct{}
+	channels  map[*amqp.Channel]struct{}
 }
 
-func (r RabbitMq) ListenOne(ctx context.Context, topicName string, subscriptionName string, handler func(context.Context, []byte) error) error {
-	channel, err := r.client.Channel()
+// DialRabbitMq connects to the broker, only delays of reconnect policy are used, reconnection is attempted until Close
+func DialRabbitMq(url string, topology RabbitTopology, retry RetryPolicy, reconnect RetryPolicy) (*RabbitMq, error) {
+	conn, err := amqp.Dial(url)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = channel.ExchangeDeclare(topicName, "fanout", false, false, false, false, nil)
-	if err != nil {
-		return err
+	r := &RabbitMq{
+		url:       url,
+		topology:  topology,
+		retry:     retry,
+		reconnect: reconnect,
+		consumers: newConsumers(),
+		connected: make(chan struct{}),
+		done:      make(chan struct{}),
+		channels:  make(map[*amqp.Channel]struct{}),
 	}
 
-	queue, err := channel.QueueDeclare(subscriptionName+"/"+topicName, false, false, true, false, nil)
-	if err != nil {
-		return err
+	r.mu.Lock()
+	closes := r.setConnection(conn)
+	r.mu.Unlock()
+
+	go r.supervise(closes)
+
+	return r, nil
+}
+
+// setConnection must be called with mu held
+func (r *RabbitMq) setConnection(conn *amqp.Connection) chan *amqp.Error {
+	r.conn = conn
+	r.lastErr = nil
+	close(r.connected)
+
+	return conn.NotifyClose(make(chan *amqp.Error, 1))
+}
+
+func (r *RabbitMq) supervise(closes chan *amqp.Error) {
+	for {
+		select {
+		case <-r.done:
+			return
+		case amqpErr := <-closes:
+			r.mu.Lock()
+			if r.closed {
+				r.mu.Unlock()
+				return
+			}
+
+			r.connected = make(chan struct{})
+			r.lastErr = errors.New("connection closed")
+			if amqpErr != nil {
+				r.lastErr = amqpErr
+			}
+			r.mu.Unlock()
+
+			logger.Error(errors.Wrap(amqpErr, "RabbitMQ connection lost, reconnecting"), &map[string]any{})
+
+			closes = r.redial()
+			if closes == nil {
+				return
+			}
+		}
 	}
+}
 
-	err = channel.QueueBind(queue.Name, "", topicName, false, nil)
-	if err != nil {
-		return err
+// redial returns nil when adapter is closed before connection is restored
+func (r *RabbitMq) redial() chan *amqp.Error {
+	for attempt := 1; ; attempt++ {
+		conn, err := amqp.Dial(r.url)
+		if err == nil {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+
+			if r.closed {
+				_ = conn.Close()
+				return nil
+			}
+
+			logger.Info(fmt.Sprintf("RabbitMQ connection restored after %d attempts", attempt), &map[string]any{})
+			return r.setConnection(conn)
+		}
+
+		r.mu.Lock()
+		r.lastErr = err
+		r.mu.Unlock()
+
+		delay := r.reconnect.delay(attempt + 1)
+		logger.Error(errors.Wrap(err, fmt.Sprintf("Failed to reconnect to RabbitMQ, retrying in %s", delay)), &map[string]any{})
+
+		select {
+		case <-time.After(delay):
+		case <-r.done:
+			return nil
+		}
 	}
+}
+
+// connection waits for open connection, nil is returned when ctx is done or adapter is closed
+func (r *RabbitMq) connection(ctx context.Context) *amqp.Connection {
+	for {
+		r.mu.Lock()
+		conn, connected, closed := r.conn, r.connected, r.closed
+		r.mu.Unlock()
+
+		if closed {
+			return nil
+		}
+
+		select {
+		case <-connected:
+			return conn
+		case <-ctx.Done():
+			return nil
+		case <-r.done:
+			return nil
+		}
+	}
+}
 
-	messagesCh, err := channel.Consume(queue.Name, "", false, false, false, false, nil)
+// Health returns error while connection to the broker is lost
+func (r *RabbitMq) Health() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return errors.New("connection is closed")
+	}
+
+	select {
+	case <-r.connected:
+		return nil
+	default:
+		return errors.Wrap(r.lastErr, "reconnecting")
+	}
+}
+
+// ListenOne consumes topic until ctx is done, messages received but not handled by then are returned to the queue.
+// Subscription is restored when channel or connection is closed by the broker
+func (r *RabbitMq) ListenOne(ctx context.Context, topicName string, subscriptionName string, handler func(context.Context, []byte) error, options ConsumerOptions) error {
+	conn := r.connection(ctx)
+	if conn == nil {
+		return errors.New("RabbitMQ connection is closed")
+	}
+
+	channel, messagesCh, err := r.consume(conn, topicName, subscriptionName, options)
 	if err != nil {
 		return err
 	}
 
-	go func() {
-		for message := range messagesCh {
-			st := time.Now()
+	r.consumers.start(func() {
+		pool := newKeyedPool(options.Workers, options.Prefetch)
+		defer pool.close()
 
-			scope := map[string]any{
-				"correlationId": message.CorrelationId,
-				"type":          "incoming:rabbitmq",
-				"messageId":     message.MessageId,
-				"topic":         topicName,
+		for {
+			if !r.deliver(ctx, pool, channel, messagesCh, topicName, handler, options) {
+				return
 			}
 
-			logger.Info(fmt.Sprintf("Message %s processing started", message.MessageId), &scope)
-			err := handler(context.WithValue(context.Background(), "correlationId", message.CorrelationId), message.Body)
-			scope["elapsedMilliseconds"] = time.Now().Sub(st).Milliseconds()
+			logger.Error(errors.Errorf("RabbitMQ channel of %s closed, resubscribing", topicName), &map[string]any{})
 
-			if err != nil {
-				_ = channel.Reject(message.DeliveryTag, true)
-				logger.Error(errors.Wrap(err, fmt.Sprintf("Message %s abandoned", message.MessageId)), &scope)
-			} else {
-				_ = channel.Ack(message.DeliveryTag, false)
-				logger.Info(fmt.Sprintf("Message %s finished", message.MessageId), &scope)
+			channel, messagesCh = r.resubscribe(ctx, topicName, subscriptionName, options)
+			if channel == nil {
+				return
 			}
 		}
-	}()
+	})
 
 	return nil
 }
+
+// deliver dispatches messages of the channel to the pool, true is returned when channel is closed before ctx is done
+func (r *RabbitMq) deliver(ctx context.Context, pool *keyedPool, channel *amqp.Channel, messagesCh <-chan amqp.Delivery, topicName string, handler func(context.Context, []byte) error, options ConsumerOptions) bool {
+	defer r.untrack(channel)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case message, ok := <-messagesCh:
+			if !ok {
+				return ctx.Err() == nil
+			}
+			// unacknowledged messages are requeued when channel is closed
+			if ctx.Err() != nil {
+				return false
+			}
+
+			if !pool.dispatch(ctx, options.key(message.Body), func() { r.handle(ctx, channel, topicName, message, handler) }) {
+				return false
+			}
+		}
+	}
+}
+
+// resubscribe returns nil channel when ctx is done or adapter is closed before subscription is restored
+func (r *RabbitMq) resubscribe(ctx context.Context, topicName string, subscriptionName string, options ConsumerOptions) (*amqp.Channel, <-chan amqp.Delivery) {
+	for attempt := 1; ; attempt++ {
+		conn := r.connection(ctx)
+		if conn == nil {
+			return nil, nil
+		}
+
+		channel, messagesCh, err := r.consume(conn, topicName, subscriptionName, options)
+		if err == nil {
+			logger.Info(fmt.Sprintf("Successfully resubscribed to topic %s", topicName), &map[string]any{})
+			return channel, messagesCh
+		}
+
+		delay := r.reconnect.delay(attempt + 1)
+		logger.Error(errors.Wrap(err, fmt.Sprintf("Failed to resubscribe to %s, retrying in %s", topicName, delay)), &map[string]any{})
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, nil
+		case <-r.done:
+			return nil, nil
+		}
+	}
+}
+
+// consume opens channel, declares topology of the topic and starts consumption of its queue
+func (r *RabbitMq) consume(conn *amqp.Connection, topicName string, subscriptionName string, options ConsumerOptions) (*amqp.Channel, <-chan amqp.Delivery, error) {
+	channel, err := conn.Channel()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	queueName, err := r.topology.declare(channel, topicName, subscriptionName)
+	if err == nil {
+		err = channel.Qos(options.Prefetch, 0, false)
+	}
+
+	var messagesCh <-chan amqp.Delivery
+	if err == nil {
+		messagesCh, err = channel.Consume(queueName, "", false, false, false, false, nil)
+	}
+
+	if err != nil {
+		_ = channel.Close()
+		return nil, nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		_ = channel.Close()
+		return nil, nil, errors.New("RabbitMQ connection is closed")
+	}
+	r.channels[channel] = struct{}{}
+
+	return channel, messagesCh, nil
+}
+
+func (r *RabbitMq) untrack(channel *amqp.Channel) {
+	r.mu.Lock()
+	delete(r.channels, channel)
+	r.mu.Unlock()
+}
+
+func (r *RabbitMq) handle(ctx context.Context, channel *amqp.Channel, topicName string, message amqp.Delivery, handler func(context.Context, []byte) error) {
+	// message was waiting for a worker when consumption stopped
+	if ctx.Err() != nil {
+		_ = channel.Reject(message.DeliveryTag, true)
+		return
+	}
+
+	st := time.Now()
+
+	scope := map[string]any{
+		"correlationId": message.CorrelationId,
+		"type":          "incoming:rabbitmq",
+		"messageId":     message.MessageId,
+		"topic":         topicName,
+	}
+
+	logger.Info(fmt.Sprintf("Message %s processing started", message.MessageId), &scope)
+	handlerCtx := context.WithValue(r.consumers.handlers, "correlationId", message.CorrelationId)
+	handlerCtx = context.WithValue(handlerCtx, "messageId", message.MessageId)
+	result, err := r.retry.handle(ctx, handlerCtx, handler, message.Body, scope)
+	scope["elapsedMilliseconds"] = time.Now().Sub(st).Milliseconds()
+
+	switch result {
+	case completed:
+		_ = channel.Ack(message.DeliveryTag, false)
+		logger.Info(fmt.Sprintf("Message %s finished", message.MessageId), &scope)
+	case abandoned:
+		_ = channel.Reject(message.DeliveryTag, true)
+		logger.Error(errors.Wrap(err, fmt.Sprintf("Message %s abandoned", message.MessageId)), &scope)
+	case deadLettered:
+		_ = channel.Reject(message.DeliveryTag, false)
+		logger.Error(errors.Wrap(err, fmt.Sprintf("Message %s dead-lettered", message.MessageId)), &scope)
+	}
+}
+
+// Close waits for in-flight messages to be settled until ctx is done and closes channels and connection
+func (r *RabbitMq) Close(ctx context.Context) error {
+	err := r.consumers.drain(ctx)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return err
+	}
+	r.closed = true
+	close(r.done)
+
+	for channel := range r.channels {
+		_ = channel.Close()
+	}
+	r.channels = nil
+
+	// connection may be already lost
+	if closeErr := r.conn.Close(); closeErr != nil && !errors.Is(closeErr, amqp.ErrClosed) && err == nil {
+		err = closeErr
+	}
+
+	return err
+}
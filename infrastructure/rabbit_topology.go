@@ -0,0 +1,90 @@
+package infrastructure
+
+import (
+	amqp "github.com/rabbitmq/amqp091-go"
+	"strings"
+	"time"
+)
+
+// RabbitTopology describes exchanges and queues declared for subscriptions
+type RabbitTopology struct {
+	ExchangeType string
+	// Durable exchanges and queues survive broker restart, non-durable queues are deleted with the last consumer
+	Durable bool
+	// QueueName is a template with {subscription} and {topic} placeholders
+	QueueName  string
+	BindingKey string
+	// MessageTtl and MaxLength limit queue when set, expired and dropped messages are dead-lettered
+	MessageTtl time.Duration
+	MaxLength  int
+	// DeadLetterExchange is a template with {queue} placeholder, empty value disables dead-lettering
+	DeadLetterExchange string
+}
+
+func DefaultRabbitTopology() RabbitTopology {
+	return RabbitTopology{
+		ExchangeType:       amqp.ExchangeFanout,
+		Durable:            true,
+		QueueName:          "{subscription}/{topic}",
+		DeadLetterExchange: "{queue}.dlx",
+	}
+}
+
+func (t RabbitTopology) queueName(subscriptionName string, topicName string) string {
+	return strings.NewReplacer("{subscription}", subscriptionName, "{topic}", topicName).Replace(t.QueueName)
+}
+
+func (t RabbitTopology) deadLetterExchange(queueName string) string {
+	return strings.ReplaceAll(t.DeadLetterExchange, "{queue}", queueName)
+}
+
+// declare creates exchange of the topic and queue of the subscription bound to it, returns name of the queue
+func (t RabbitTopology) declare(channel *amqp.Channel, topicName string, subscriptionName string) (string, error) {
+	// exchange is shared with publishers, it is never deleted with bindings of our queues
+	err := channel.ExchangeDeclare(topicName, t.ExchangeType, t.Durable, false, false, false, nil)
+	if err != nil {
+		return "", err
+	}
+
+	queueName := t.queueName(subscriptionName, topicName)
+	args := amqp.Table{}
+
+	if t.DeadLetterExchange != "" {
+		// rejected messages are routed to dead-letter queue of the subscription
+		deadLetterExchange := t.deadLetterExchange(queueName)
+		err = channel.ExchangeDeclare(deadLetterExchange, amqp.ExchangeFanout, true, false, false, false, nil)
+		if err != nil {
+			return "", err
+		}
+
+		deadLetterQueue, err := channel.QueueDeclare(queueName+".dlq", true, false, false, false, nil)
+		if err != nil {
+			return "", err
+		}
+
+		err = channel.QueueBind(deadLetterQueue.Name, "", deadLetterExchange, false, nil)
+		if err != nil {
+			return "", err
+		}
+
+		args["x-dead-letter-exchange"] = deadLetterExchange
+	}
+	if t.MessageTtl > 0 {
+		args["x-message-ttl"] = t.MessageTtl.Milliseconds()
+	}
+	if t.MaxLength > 0 {
+		args["x-max-length"] = t.MaxLength
+	}
+
+	queue, err := channel.QueueDeclare(queueName, t.Durable, !t.Durable, false, false, args)
+	if err != nil {
+		return "", err
+	}
+
+	err = channel.QueueBind(queue.Name, t.BindingKey, topicName, false, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return queue.Name, nil
+}
@@ -33,6 +33,10 @@ func (c RelationsClient) GetFriends(ctx context.Context, user string, skip int,
 	}
 	defer resp.Body.Close()
 
+	if err := checkResponse(resp); err != nil {
+		return nil, err
+	}
+
 	rb, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
@@ -58,6 +62,10 @@ func (c RelationsClient) GetOutgoingRequests(ctx context.Context, user string, s
 	}
 	defer resp.Body.Close()
 
+	if err := checkResponse(resp); err != nil {
+		return nil, err
+	}
+
 	rb, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
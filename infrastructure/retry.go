@@ -0,0 +1,85 @@
+package infrastructure
+
+import (
+	"context"
+	"fmt"
+	"github.com/ghosts-network/news-feed/utils/logger"
+	"github.com/pkg/errors"
+	"time"
+)
+
+// RetryPolicy describes how many times and how often failed message is handled again before it is dead-lettered
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+}
+
+func NewRetryPolicy(maxAttempts int, backoff time.Duration, maxBackoff time.Duration) RetryPolicy {
+	if maxAttempts <= 0 {
+		maxAttempts = 1
+	}
+	if maxBackoff < backoff {
+		maxBackoff = backoff
+	}
+
+	return RetryPolicy{MaxAttempts: maxAttempts, Backoff: backoff, MaxBackoff: maxBackoff}
+}
+
+// delay returns exponential backoff before given attempt, attempts are counted from 1
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	d := p.Backoff
+	for i := 2; i < attempt && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+
+	return d
+}
+
+// IsPermanent reports whether error can't be fixed by handling message again, e.g. message is malformed
+func IsPermanent(err error) bool {
+	var permanent interface{ Permanent() bool }
+	return errors.As(err, &permanent) && permanent.Permanent()
+}
+
+type outcome int
+
+const (
+	completed outcome = iota
+	// abandoned message is returned to the broker to be delivered again
+	abandoned
+	deadLettered
+)
+
+// handle runs handler until it succeeds, fails permanently or attempts are exhausted,
+// retries are stopped and message is abandoned when ctx is done
+func (p RetryPolicy) handle(ctx context.Context, handlerCtx context.Context, handler func(context.Context, []byte) error, body []byte, scope map[string]any) (outcome, error) {
+	for attempt := 1; ; attempt++ {
+		err := handler(handlerCtx, body)
+		if err == nil {
+			return completed, nil
+		}
+		if IsPermanent(err) {
+			return deadLettered, err
+		}
+		// failure could be caused by shutdown, so message is left for next consumer
+		if ctx.Err() != nil {
+			return abandoned, err
+		}
+		if attempt >= p.MaxAttempts {
+			return deadLettered, err
+		}
+
+		delay := p.delay(attempt + 1)
+		logger.Error(errors.Wrap(err, fmt.Sprintf("Attempt %d of %d failed, retrying in %s", attempt, p.MaxAttempts, delay)), &scope)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return abandoned, err
+		}
+	}
+}
@@ -6,50 +6,236 @@ import (
 	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
 	"github.com/ghosts-network/news-feed/utils/logger"
 	"github.com/pkg/errors"
+	"sync"
 	"time"
 )
 
 type ServiceBus struct {
-	client *azservicebus.Client
+	client    *azservicebus.Client
+	retry     RetryPolicy
+	options   ServiceBusOptions
+	consumers *consumers
+
+	mu        sync.Mutex
+	receivers []*azservicebus.Receiver
+}
+
+// ServiceBusOptions configures receiving of messages
+type ServiceBusOptions struct {
+	// BatchSize is maximum number of messages received at once, prefetch of the subscription is used when it is not set
+	BatchSize int
+	// ReceiveBackoff delays receiving after failure, only delays are used, receiving is retried until ctx is done
+	ReceiveBackoff RetryPolicy
+	// MaxLockRenewal limits time locks of received messages are renewed for
+	MaxLockRenewal time.Duration
+}
+
+func DefaultServiceBusOptions() ServiceBusOptions {
+	return ServiceBusOptions{
+		ReceiveBackoff: NewRetryPolicy(0, time.Second, 30*time.Second),
+		MaxLockRenewal: 5 * time.Minute,
+	}
 }
 
-func NewServiceBus(client *azservicebus.Client) *ServiceBus {
-	return &ServiceBus{client: client}
+func NewServiceBus(client *azservicebus.Client, retry RetryPolicy, options ServiceBusOptions) *ServiceBus {
+	return &ServiceBus{client: client, retry: retry, options: options, consumers: newConsumers()}
 }
 
-func (eb ServiceBus) ListenOne(ctx context.Context, topicName string, subscriptionName string, handler func(context.Context, []byte) error) error {
+// ListenOne receives messages of the subscription until ctx is done, locks of received messages are renewed until they are settled
+func (eb *ServiceBus) ListenOne(ctx context.Context, topicName string, subscriptionName string, handler func(context.Context, []byte) error, options ConsumerOptions) error {
 	receiver, err := eb.client.NewReceiverForSubscription(topicName, subscriptionName, nil)
 	if err != nil {
 		return err
 	}
 
-	go func(receiver *azservicebus.Receiver) {
-		for {
-			messages, _ := receiver.ReceiveMessages(ctx, 1, nil)
-			for _, message := range messages {
-				st := time.Now()
+	eb.mu.Lock()
+	eb.receivers = append(eb.receivers, receiver)
+	eb.mu.Unlock()
 
-				scope := map[string]any{
-					"correlationId": message.CorrelationID,
-					"type":          "incoming:servicebus",
-					"messageId":     message.MessageID,
-					"topic":         topicName,
+	batchSize := eb.options.BatchSize
+	if batchSize <= 0 {
+		batchSize = options.Prefetch
+	}
+
+	eb.consumers.start(func() {
+		pool := newKeyedPool(options.Workers, options.Prefetch)
+		defer pool.close()
+
+		failures := 0
+		for ctx.Err() == nil {
+			messages, err := receiver.ReceiveMessages(ctx, batchSize, nil)
+			if err != nil {
+				if ctx.Err() != nil {
+					return
 				}
 
-				logger.Info(fmt.Sprintf("Message %s processing started", message.MessageID), &scope)
-				err := handler(context.WithValue(context.Background(), "correlationId", message.CorrelationID), message.Body)
-				scope["elapsedMilliseconds"] = time.Now().Sub(st).Milliseconds()
+				failures++
+				delay := eb.options.ReceiveBackoff.delay(failures + 1)
+				logger.Error(errors.Wrap(err, fmt.Sprintf("Failed to receive messages of %s, retrying in %s", topicName, delay)), &map[string]any{
+					"topic": topicName,
+				})
+
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+				}
+				continue
+			}
+			failures = 0
 
-				if err != nil {
-					_ = receiver.AbandonMessage(ctx, message, nil)
-					logger.Error(errors.Wrap(err, fmt.Sprintf("Message %s abandoned", message.MessageID)), &scope)
-				} else {
-					_ = receiver.CompleteMessage(ctx, message, nil)
-					logger.Info(fmt.Sprintf("Message %s finished", message.MessageID), &scope)
+			for _, message := range messages {
+				message := message
+				stopRenewal := eb.renewLock(receiver, topicName, message)
+				if !pool.dispatch(ctx, options.key(message.Body), func() { eb.handle(ctx, receiver, topicName, message, handler, stopRenewal) }) {
+					stopRenewal()
+					eb.abandon(receiver, topicName, message)
 				}
 			}
 		}
-	}(receiver)
+	})
 
 	return nil
 }
+
+// renewLock keeps message locked while it waits for a worker and is handled, returned func stops renewal
+func (eb *ServiceBus) renewLock(receiver *azservicebus.Receiver, topicName string, message *azservicebus.ReceivedMessage) func() {
+	ctx, cancel := context.WithTimeout(context.Background(), eb.options.MaxLockRenewal)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for {
+			select {
+			case <-time.After(lockRenewalInterval(message)):
+			case <-ctx.Done():
+				return
+			}
+
+			if err := receiver.RenewMessageLock(ctx, message, nil); err != nil {
+				if ctx.Err() == nil {
+					// settlement fails without lock and message is delivered again
+					logger.Error(errors.Wrap(err, fmt.Sprintf("Failed to renew lock of message %s", message.MessageID)), &map[string]any{
+						"correlationId": message.CorrelationID,
+						"topic":         topicName,
+					})
+				}
+				return
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// lockRenewalInterval renews lock when half of its duration is left
+func lockRenewalInterval(message *azservicebus.ReceivedMessage) time.Duration {
+	if message.LockedUntil == nil {
+		return 10 * time.Second
+	}
+
+	interval := time.Until(*message.LockedUntil) / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+
+	return interval
+}
+
+func (eb *ServiceBus) handle(ctx context.Context, receiver *azservicebus.Receiver, topicName string, message *azservicebus.ReceivedMessage, handler func(context.Context, []byte) error, stopRenewal func()) {
+	if ctx.Err() != nil {
+		stopRenewal()
+		eb.abandon(receiver, topicName, message)
+		return
+	}
+
+	st := time.Now()
+
+	scope := map[string]any{
+		"correlationId": message.CorrelationID,
+		"type":          "incoming:servicebus",
+		"messageId":     message.MessageID,
+		"topic":         topicName,
+	}
+
+	logger.Info(fmt.Sprintf("Message %s processing started", message.MessageID), &scope)
+	handlerCtx := context.WithValue(eb.consumers.handlers, "correlationId", message.CorrelationID)
+	handlerCtx = context.WithValue(handlerCtx, "messageId", message.MessageID)
+	result, err := eb.retry.handle(ctx, handlerCtx, handler, message.Body, scope)
+	scope["elapsedMilliseconds"] = time.Now().Sub(st).Milliseconds()
+
+	// renewal must not race with settlement of the message
+	stopRenewal()
+
+	settleCtx, cancel := settleContext()
+	defer cancel()
+
+	switch result {
+	case completed:
+		if settleErr := receiver.CompleteMessage(settleCtx, message, nil); settleErr != nil {
+			logger.Error(errors.Wrap(settleErr, fmt.Sprintf("Failed to complete message %s", message.MessageID)), &scope)
+			return
+		}
+		logger.Info(fmt.Sprintf("Message %s finished", message.MessageID), &scope)
+	case abandoned:
+		if settleErr := receiver.AbandonMessage(settleCtx, message, nil); settleErr != nil {
+			logger.Error(errors.Wrap(settleErr, fmt.Sprintf("Failed to abandon message %s", message.MessageID)), &scope)
+		}
+		logger.Error(errors.Wrap(err, fmt.Sprintf("Message %s abandoned", message.MessageID)), &scope)
+	case deadLettered:
+		reason, description := deadLetterReason(err), err.Error()
+		if settleErr := receiver.DeadLetterMessage(settleCtx, message, &azservicebus.DeadLetterOptions{
+			Reason:           &reason,
+			ErrorDescription: &description,
+		}); settleErr != nil {
+			logger.Error(errors.Wrap(settleErr, fmt.Sprintf("Failed to dead-letter message %s", message.MessageID)), &scope)
+		}
+		logger.Error(errors.Wrap(err, fmt.Sprintf("Message %s dead-lettered", message.MessageID)), &scope)
+	}
+}
+
+// abandon returns message which was not handled because consumption stopped
+func (eb *ServiceBus) abandon(receiver *azservicebus.Receiver, topicName string, message *azservicebus.ReceivedMessage) {
+	settleCtx, cancel := settleContext()
+	defer cancel()
+
+	if err := receiver.AbandonMessage(settleCtx, message, nil); err != nil {
+		logger.Error(errors.Wrap(err, fmt.Sprintf("Failed to abandon message %s", message.MessageID)), &map[string]any{
+			"correlationId": message.CorrelationID,
+			"topic":         topicName,
+		})
+	}
+}
+
+// Close waits for in-flight messages to be settled until ctx is done and closes receivers and client
+func (eb *ServiceBus) Close(ctx context.Context) error {
+	err := eb.consumers.drain(ctx)
+
+	closeCtx, cancel := settleContext()
+	defer cancel()
+
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+
+	for _, receiver := range eb.receivers {
+		_ = receiver.Close(closeCtx)
+	}
+	eb.receivers = nil
+
+	if closeErr := eb.client.Close(closeCtx); closeErr != nil && err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+func deadLetterReason(err error) string {
+	if IsPermanent(err) {
+		return "MessageRejected"
+	}
+
+	return "MaxAttemptsExceeded"
+}
@@ -1,32 +1,83 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"github.com/ghosts-network/news-feed/app/api"
 	"github.com/ghosts-network/news-feed/app/listener"
+	"github.com/ghosts-network/news-feed/app/rpc"
+	"github.com/ghosts-network/news-feed/news"
+	"github.com/ghosts-network/news-feed/utils/env"
+	"github.com/ghosts-network/news-feed/utils/health"
+	"github.com/ghosts-network/news-feed/utils/logger"
+	"github.com/pkg/errors"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 )
 
 func main() {
 	serverEnabled := flag.Bool("server.enable", false, "Enable/disable web server")
+	grpcEnabled := flag.Bool("grpc.enable", false, "Enable/disable grpc server")
 	listenedEnabled := flag.Bool("listener.enable", false, "Enable/disable events listener")
 
 	flag.Parse()
 
-	sigc := make(chan os.Signal, 1)
-	lsigc := make(chan os.Signal, 1)
-	signal.Notify(sigc, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
-	signal.Notify(lsigc, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
+	defer stop()
+
+	wg := &sync.WaitGroup{}
+	run := func(f func(ctx context.Context)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f(ctx)
+		}()
+	}
+
+	newsStorage := news.NewMongoNewsStorage(os.Getenv("MONGO_CONNECTION"))
+	newsStorage.SetCache(news.NewFeedCache(
+		env.Int("FEED_CACHE_USERS", 0),
+		env.Duration("FEED_CACHE_TTL", time.Minute)))
+
+	indexesCtx, indexesCancel := context.WithTimeout(ctx, 10*time.Second)
+	if err := newsStorage.EnsureIndexes(indexesCtx); err != nil {
+		logger.Error(errors.Wrap(err, "Failed to create indexes"), &map[string]any{})
+	}
+	indexesCancel()
+
+	// components register checks of their dependencies, they are served by web server or on HEALTH_ADDRESS
+	checks := health.NewChecks()
 
 	if *serverEnabled {
-		go api.RunServer()
+		run(func(ctx context.Context) { api.RunServer(ctx, newsStorage, checks) })
+	} else if address := os.Getenv("HEALTH_ADDRESS"); address != "" {
+		run(func(ctx context.Context) { health.ListenAndServe(ctx, address, checks) })
+	}
+
+	if *grpcEnabled {
+		run(func(ctx context.Context) { rpc.RunServer(ctx, newsStorage) })
 	}
 
 	if *listenedEnabled {
-		go listener.NewListener(lsigc).Run()
+		run(listener.NewListener(newsStorage, checks).Run)
 	}
 
-	<-sigc
+	<-ctx.Done()
+	// second signal terminates process immediately
+	stop()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(env.Duration("SHUTDOWN_TIMEOUT", 30*time.Second)):
+		logger.Error(errors.New("Graceful shutdown timed out"), &map[string]any{})
+	}
 }
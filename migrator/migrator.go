@@ -61,9 +61,16 @@ func (m Migrator) MigrateUsers(ctx context.Context) {
 	})
 }
 
-func (m Migrator) MigrateUser(ctx context.Context, user string) {
+func (m Migrator) MigrateUser(ctx context.Context, user string) error {
 	st := time.Now()
 
+	if _, err := m.pc.GetProfile(ctx, user); err != nil {
+		if errors.Is(err, infrastructure.ErrNotFound) {
+			return news.ErrUnknownUser
+		}
+		return errors.Wrap(err, fmt.Sprintf("Failed to fetch profile %s", user))
+	}
+
 	_ = m.ns.RemoveUserSources(ctx, user)
 	_ = m.ns.RemoveNews(ctx, user)
 	m.migrateFriends(ctx, user)
@@ -73,6 +80,8 @@ func (m Migrator) MigrateUser(ctx context.Context, user string) {
 		"correlationId":       ctx.Value("correlationId"),
 		"elapsedMilliseconds": time.Now().Sub(st).Milliseconds(),
 	})
+
+	return nil
 }
 
 func (m Migrator) MigrateUserAsync(ctx context.Context, user string, wg *sync.WaitGroup) {
@@ -149,7 +158,7 @@ func (m Migrator) migrateFriends(ctx context.Context, user string) {
 			break
 		}
 
-		if err = m.ns.AddUserSources(ctx, user, friends); err != nil {
+		if err = m.ns.AddUserSources(ctx, user, friends, news.FriendSource); err != nil {
 			logger.Error(errors.Wrap(err, fmt.Sprintf("Failed to migrate friends batch (%d, %d) for %s", skip, take, user)), &map[string]any{
 				"correlationId": ctx.Value("correlationId"),
 			})
@@ -183,7 +192,7 @@ func (m Migrator) migrateOutgoingRequests(ctx context.Context, user string) {
 			break
 		}
 
-		if err = m.ns.AddUserSources(ctx, user, rs); err != nil {
+		if err = m.ns.AddUserSources(ctx, user, rs, news.PendingRequestSource); err != nil {
 			logger.Error(errors.Wrap(err, fmt.Sprintf("Failed to migrate outgoing requests batch (%d, %d) for %s", skip, take, user)), &map[string]any{
 				"correlationId": ctx.Value("correlationId"),
 			})
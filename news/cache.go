@@ -0,0 +1,176 @@
+package news
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"golang.org/x/sync/singleflight"
+	"sync"
+	"time"
+)
+
+// loadTimeout limits shared load of a page, it doesn't depend on callers waiting for it
+const loadTimeout = 10 * time.Second
+
+// FeedCache keeps first pages of feeds with hydrated publications in memory.
+// Pages are keyed on persisted version of the feed, so writes of any process make them stale
+type FeedCache struct {
+	ttl time.Duration
+
+	mu    sync.Mutex
+	pages *lru[string, cachedPage]
+
+	loads singleflight.Group
+}
+
+type cachedPage struct {
+	version int64
+	// take used to fetch the page, page shorter than take is the whole feed
+	take         int
+	publications []Publication
+}
+
+// NewFeedCache returns nil when caching is disabled
+func NewFeedCache(users int, ttl time.Duration) *FeedCache {
+	if users <= 0 {
+		return nil
+	}
+
+	return &FeedCache{ttl: ttl, pages: newLru[string, cachedPage](users)}
+}
+
+// firstPage loads page once for concurrent callers. Load is detached from context of the caller which started it,
+// so cancelled request doesn't fail requests of other callers, each caller waits until its own ctx is done
+func (c *FeedCache) firstPage(ctx context.Context, user string, version int64, take int, load func(context.Context) ([]Publication, error)) ([]Publication, error) {
+	if c == nil {
+		return load(ctx)
+	}
+
+	c.mu.Lock()
+	page, ok := c.pages.get(user)
+	c.mu.Unlock()
+
+	if ok && page.version == version && (take <= page.take || len(page.publications) < page.take) {
+		return append([]Publication(nil), page.publications[:minInt(take, len(page.publications))]...), nil
+	}
+
+	loaded := c.loads.DoChan(fmt.Sprintf("%s/%d/%d", user, take, version), func() (any, error) {
+		loadCtx, cancel := context.WithTimeout(context.WithValue(context.Background(), "correlationId", ctx.Value("correlationId")), loadTimeout)
+		defer cancel()
+
+		ps, err := load(loadCtx)
+		if err != nil {
+			return nil, err
+		}
+
+		// page loaded after version was read can only be newer, it is never served for later versions
+		c.mu.Lock()
+		if current, ok := c.pages.get(user); !ok || current.version <= version {
+			c.pages.add(user, cachedPage{version: version, take: take, publications: ps}, c.ttl)
+		}
+		c.mu.Unlock()
+
+		return ps, nil
+	})
+
+	select {
+	case result := <-loaded:
+		if result.Err != nil {
+			return nil, result.Err
+		}
+
+		return append([]Publication(nil), result.Val.([]Publication)...), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// invalidateUsers drops pages which became stale, pages of other processes are skipped by version
+func (c *FeedCache) invalidateUsers(users ...string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, user := range users {
+		c.pages.remove(user)
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+// lru is not safe for concurrent use
+type lru[K comparable, V any] struct {
+	capacity int
+	items    map[K]*list.Element
+	order    *list.List
+}
+
+type lruEntry[K comparable, V any] struct {
+	key     K
+	value   V
+	expires time.Time
+}
+
+func newLru[K comparable, V any](capacity int) *lru[K, V] {
+	return &lru[K, V]{
+		capacity: capacity,
+		items:    make(map[K]*list.Element, capacity),
+		order:    list.New(),
+	}
+}
+
+func (l *lru[K, V]) get(key K) (V, bool) {
+	var zero V
+
+	e, ok := l.items[key]
+	if !ok {
+		return zero, false
+	}
+
+	entry := e.Value.(*lruEntry[K, V])
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		l.order.Remove(e)
+		delete(l.items, key)
+		return zero, false
+	}
+
+	l.order.MoveToFront(e)
+	return entry.value, true
+}
+
+// add stores value for ttl, zero ttl keeps value until it is evicted
+func (l *lru[K, V]) add(key K, value V, ttl time.Duration) {
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
+	}
+
+	if e, ok := l.items[key]; ok {
+		e.Value = &lruEntry[K, V]{key: key, value: value, expires: expires}
+		l.order.MoveToFront(e)
+		return
+	}
+
+	l.items[key] = l.order.PushFront(&lruEntry[K, V]{key: key, value: value, expires: expires})
+	if l.order.Len() > l.capacity {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.items, oldest.Value.(*lruEntry[K, V]).key)
+	}
+}
+
+func (l *lru[K, V]) remove(key K) {
+	if e, ok := l.items[key]; ok {
+		l.order.Remove(e)
+		delete(l.items, key)
+	}
+}
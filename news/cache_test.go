@@ -0,0 +1,53 @@
+package news
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFeedCache_ReloadsPageWhenVersionChanges(t *testing.T) {
+	cache := NewFeedCache(10, time.Minute)
+	ctx := context.Background()
+
+	loads := 0
+	load := func(content string) func(context.Context) ([]Publication, error) {
+		return func(context.Context) ([]Publication, error) {
+			loads++
+			return []Publication{{Id: "1", Content: content}}, nil
+		}
+	}
+
+	if _, err := cache.firstPage(ctx, "user", 1, 20, load("original")); err != nil {
+		t.Fatal(err)
+	}
+
+	ps, err := cache.firstPage(ctx, "user", 1, 20, load("original"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loads != 1 || ps[0].Content != "original" {
+		t.Errorf("page of the same version is loaded %d times, expected once", loads)
+	}
+
+	// version is changed by write of other process, which doesn't invalidate this cache
+	ps, err = cache.firstPage(ctx, "user", 2, 20, load("updated"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loads != 2 || ps[0].Content != "updated" {
+		t.Errorf("page of new version is %+v, expected updated publication", ps)
+	}
+
+	// page of older version doesn't replace newer one
+	if _, err := cache.firstPage(ctx, "user", 1, 20, load("original")); err != nil {
+		t.Fatal(err)
+	}
+	ps, err = cache.firstPage(ctx, "user", 2, 20, load("updated"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loads != 3 || ps[0].Content != "updated" {
+		t.Errorf("page of new version is loaded %d times, expected to be kept in cache", loads)
+	}
+}
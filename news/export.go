@@ -0,0 +1,93 @@
+package news
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ExportUserData passes everything stored about user to emit: sources, news and own publications, in that order.
+// Collections are read in pages of pageSize documents, so memory usage doesn't depend on size of the account
+func (storage *MongoNewsStorage) ExportUserData(ctx context.Context, user string, pageSize int, emit func(ExportRecord) error) error {
+	err := exportCollection(ctx, storage.sources, bson.D{{"user", user}}, pageSize, func(raw bson.Raw) error {
+		var s sourceStruct
+		if err := bson.Unmarshal(raw, &s); err != nil {
+			return err
+		}
+
+		return emit(ExportRecord{Type: SourceRecord, Data: Source{Id: s.Source, Kind: s.Kind}})
+	})
+	if err != nil {
+		return err
+	}
+
+	err = exportCollection(ctx, storage.news, bson.D{{"user", user}}, pageSize, func(raw bson.Raw) error {
+		var n newsStruct
+		if err := bson.Unmarshal(raw, &n); err != nil {
+			return err
+		}
+
+		return emit(ExportRecord{Type: NewsRecord, Data: n.toNewsEntry()})
+	})
+	if err != nil {
+		return err
+	}
+
+	return exportCollection(ctx, storage.publications, bson.D{{"author._id", user}}, pageSize, func(raw bson.Raw) error {
+		var p publicationStruct
+		if err := bson.Unmarshal(raw, &p); err != nil {
+			return err
+		}
+
+		return emit(ExportRecord{Type: PublicationRecord, Data: p.toPublication()})
+	})
+}
+
+// exportCollection walks documents matching filter page by page ordered by _id,
+// every page is a separate query so no cursor is kept open while emit writes to slow client
+func exportCollection(ctx context.Context, collection *mongo.Collection, filter bson.D, pageSize int, emit func(bson.Raw) error) error {
+	var lastId any
+	for {
+		pageFilter := filter
+		if lastId != nil {
+			pageFilter = append(bson.D{{"_id", bson.D{{"$gt", lastId}}}}, filter...)
+		}
+
+		cur, err := collection.Find(ctx, pageFilter,
+			options.Find().
+				SetSort(bson.D{{"_id", 1}}).
+				SetLimit(int64(pageSize)))
+		if err != nil {
+			return err
+		}
+
+		var page []bson.Raw
+		for cur.Next(ctx) {
+			page = append(page, append(bson.Raw(nil), cur.Current...))
+		}
+		err = cur.Err()
+		_ = cur.Close(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, raw := range page {
+			if err := emit(raw); err != nil {
+				return err
+			}
+		}
+
+		if len(page) < pageSize {
+			return nil
+		}
+
+		var last struct {
+			Id any `bson:"_id"`
+		}
+		if err := bson.Unmarshal(page[len(page)-1], &last); err != nil {
+			return err
+		}
+		lastId = last.Id
+	}
+}
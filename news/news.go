@@ -9,6 +9,8 @@ type Publication struct {
 	CreatedOn time.Time          `json:"createdOn"`
 	UpdatedOn time.Time          `json:"updatedOn"`
 	Media     []*Media           `json:"media"`
+
+	Provenance *Provenance `json:"provenance,omitempty"`
 }
 
 type PublicationAuthor struct {
@@ -20,3 +22,52 @@ type PublicationAuthor struct {
 type Media struct {
 	Link string `json:"link" bson:"link"`
 }
+
+type SourceKind string
+
+const (
+	FriendSource         SourceKind = "friend"
+	PendingRequestSource SourceKind = "pendingRequest"
+)
+
+type Source struct {
+	Id   string     `json:"id"`
+	Kind SourceKind `json:"kind,omitempty"`
+}
+
+// Provenance explains why publication appears in the feed
+type Provenance struct {
+	Reason SourceKind `json:"reason,omitempty"`
+	Source string     `json:"source"`
+}
+
+type NewsEntry struct {
+	PublicationId string     `json:"publicationId"`
+	Source        string     `json:"source"`
+	Kind          SourceKind `json:"kind,omitempty"`
+	Order         time.Time  `json:"order"`
+}
+
+type Explanation struct {
+	User          string       `json:"user"`
+	PublicationId string       `json:"publicationId"`
+	InFeed        bool         `json:"inFeed"`
+	Verdict       string       `json:"verdict"`
+	Publication   *Publication `json:"publication"`
+	Source        *Provenance  `json:"source"`
+	News          *NewsEntry   `json:"news"`
+}
+
+type ExportRecordType string
+
+const (
+	SourceRecord      ExportRecordType = "source"
+	NewsRecord        ExportRecordType = "news"
+	PublicationRecord ExportRecordType = "publication"
+)
+
+// ExportRecord is a single piece of data held about user
+type ExportRecord struct {
+	Type ExportRecordType `json:"type"`
+	Data any              `json:"data"`
+}
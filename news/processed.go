@@ -0,0 +1,61 @@
+package news
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// ProcessedMessages remembers keys of handled messages for ttl, so redelivered messages can be skipped
+type ProcessedMessages struct {
+	collection *mongo.Collection
+}
+
+// indexOptionsConflict is returned when index with the same keys exists with other options
+const indexOptionsConflict = 85
+
+// ProcessedMessages creates TTL index on first use, expiration of existing index is changed to ttl
+func (storage *MongoNewsStorage) ProcessedMessages(ctx context.Context, ttl time.Duration) (*ProcessedMessages, error) {
+	collection := storage.news.Database().Collection("processedMessages")
+	expireAfter := int32(ttl.Seconds())
+
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{"processedOn", 1}},
+		Options: options.Index().SetExpireAfterSeconds(expireAfter),
+	})
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) && serverErr.HasErrorCode(indexOptionsConflict) {
+		err = collection.Database().RunCommand(ctx, bson.D{
+			{"collMod", collection.Name()},
+			{"index", bson.D{
+				{"keyPattern", bson.D{{"processedOn", 1}}},
+				{"expireAfterSeconds", expireAfter},
+			}},
+		}).Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &ProcessedMessages{collection: collection}, nil
+}
+
+// Claim records key before message is handled, false means key is already claimed by other delivery
+func (m *ProcessedMessages) Claim(ctx context.Context, key string) (bool, error) {
+	_, err := m.collection.InsertOne(ctx, bson.D{{"_id", key}, {"processedOn", time.Now().UTC()}})
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// Release drops claim of message which failed to be handled, so it is handled when delivered again
+func (m *ProcessedMessages) Release(ctx context.Context, key string) error {
+	_, err := m.collection.DeleteOne(ctx, bson.D{{"_id", key}})
+
+	return err
+}
@@ -2,19 +2,32 @@ package news
 
 import (
 	"context"
+	"fmt"
 	"github.com/ghosts-network/news-feed/utils/logger"
+	"github.com/pkg/errors"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/event"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"sync"
 	"time"
 )
 
+var (
+	ErrPublicationNotFound = errors.New("publication not found")
+	ErrInvalidCursor       = errors.New("invalid cursor")
+	ErrUnknownUser         = errors.New("unknown user")
+	ErrUnknownField        = errors.New("unknown publication field")
+	ErrSourceExists        = errors.New("source already exists")
+)
+
 type MongoNewsStorage struct {
 	publications *mongo.Collection
 	sources      *mongo.Collection
 	news         *mongo.Collection
+	versions     *mongo.Collection
+	cache        *FeedCache
 }
 
 func NewMongoNewsStorage(connectionString string) *MongoNewsStorage {
@@ -49,22 +62,44 @@ func NewMongoNewsStorage(connectionString string) *MongoNewsStorage {
 		publications: mc.Database("newsfeed").Collection("publications"),
 		sources:      mc.Database("newsfeed").Collection("sources"),
 		news:         mc.Database("newsfeed").Collection("news"),
+		versions:     mc.Database("newsfeed").Collection("feedVersions"),
 	}
 }
 
-func (storage *MongoNewsStorage) AddUserSources(ctx context.Context, user string, sources []string) error {
+// EnsureIndexes creates unique index of user sources, it fails while sources have duplicates
+func (storage *MongoNewsStorage) EnsureIndexes(ctx context.Context) error {
+	_, err := storage.sources.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{"user", 1}, {"source", 1}},
+		Options: options.Index().SetUnique(true),
+	})
+
+	return err
+}
+
+// SetCache makes storage serve first pages of feeds from cache, nil disables caching
+func (storage *MongoNewsStorage) SetCache(cache *FeedCache) {
+	storage.cache = cache
+}
+
+func (storage *MongoNewsStorage) AddUserSources(ctx context.Context, user string, sources []string, kind SourceKind) (err error) {
 	documents := make([]any, 0, len(sources))
 	for _, source := range sources {
 		documents = append(documents, bson.D{
 			{"user", user},
 			{"source", source},
+			{"kind", kind},
 		})
 	}
 
-	_, err := storage.sources.InsertMany(ctx, documents)
+	_, err = storage.sources.InsertMany(ctx, documents)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		if changedErr := storage.feedsChanged(ctx, user); err == nil {
+			err = changedErr
+		}
+	}()
 
 	for _, source := range sources {
 		ps, err := storage.findPublications(ctx, source)
@@ -75,6 +110,7 @@ func (storage *MongoNewsStorage) AddUserSources(ctx context.Context, user string
 				Source:        source,
 				User:          user,
 				Order:         p.CreatedOn,
+				Kind:          kind,
 			})
 		}
 
@@ -90,13 +126,17 @@ func (storage *MongoNewsStorage) AddUserSources(ctx context.Context, user string
 	return nil
 }
 
-func (storage *MongoNewsStorage) AddUserSource(ctx context.Context, user string, source string) error {
+func (storage *MongoNewsStorage) AddUserSource(ctx context.Context, user string, source string, kind SourceKind) error {
 	d := bson.D{
 		{"user", user},
 		{"source", source},
+		{"kind", kind},
 	}
 
 	_, err := storage.sources.InsertOne(ctx, d)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrSourceExists
+	}
 	if err != nil {
 		return err
 	}
@@ -110,12 +150,96 @@ func (storage *MongoNewsStorage) AddUserSource(ctx context.Context, user string,
 			Source:        source,
 			User:          user,
 			Order:         p.CreatedOn,
+			Kind:          kind,
 		})
 	}
 
 	if len(news) > 0 {
 		_, err = storage.news.InsertMany(ctx, news)
 	}
+	if changedErr := storage.feedsChanged(ctx, user); err == nil {
+		err = changedErr
+	}
+
+	return err
+}
+
+// UpdateUserSourceKind changes relation between user and source, e.g. when pending request becomes a friendship
+func (storage *MongoNewsStorage) UpdateUserSourceKind(ctx context.Context, user string, source string, kind SourceKind) error {
+	f := bson.D{{"user", user}, {"source", source}}
+	d := bson.D{{"$set", bson.D{{"kind", kind}}}}
+
+	_, err := storage.sources.UpdateMany(ctx, f, d)
+	if err != nil {
+		return err
+	}
+
+	_, err = storage.news.UpdateMany(ctx, f, d)
+	if changedErr := storage.feedsChanged(ctx, user); err == nil {
+		err = changedErr
+	}
+
+	return err
+}
+
+// ReplaceUserSources applies difference between current and given sources of the user in a single transaction,
+// news of removed sources are purged and news of added sources are backfilled
+func (storage *MongoNewsStorage) ReplaceUserSources(ctx context.Context, user string, sources []Source) error {
+	session, err := storage.sources.Database().Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		cur, err := storage.sources.Find(sc, bson.D{{"user", user}})
+		if err != nil {
+			return nil, err
+		}
+
+		current := make(map[string]SourceKind)
+		for cur.Next(sc) {
+			var result sourceStruct
+			if err := cur.Decode(&result); err != nil {
+				_ = cur.Close(sc)
+				return nil, err
+			}
+			current[result.Source] = result.Kind
+		}
+		if err := cur.Err(); err != nil {
+			_ = cur.Close(sc)
+			return nil, err
+		}
+		_ = cur.Close(sc)
+
+		desired := make(map[string]SourceKind, len(sources))
+		for _, source := range sources {
+			desired[source.Id] = source.Kind
+		}
+
+		for source := range current {
+			if _, ok := desired[source]; !ok {
+				if err := storage.RemoveUserSource(sc, user, source); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		for source, kind := range desired {
+			currentKind, ok := current[source]
+			switch {
+			case !ok:
+				err = storage.AddUserSource(sc, user, source, kind)
+			case currentKind != kind:
+				err = storage.UpdateUserSourceKind(sc, user, source, kind)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		return nil, nil
+	})
 
 	return err
 }
@@ -127,18 +251,35 @@ func (storage *MongoNewsStorage) RemoveUserSource(ctx context.Context, user stri
 	}
 
 	_, err = storage.news.DeleteMany(ctx, bson.D{{"user", user}, {"source", source}})
+	if changedErr := storage.feedsChanged(ctx, user); err == nil {
+		err = changedErr
+	}
 
 	return err
 }
 
 func (storage *MongoNewsStorage) RemoveNews(ctx context.Context, user string) error {
 	_, err := storage.news.DeleteMany(ctx, bson.D{{"user", user}})
+	if changedErr := storage.feedsChanged(ctx, user); err == nil {
+		err = changedErr
+	}
+
 	return err
 }
 
 func (storage *MongoNewsStorage) RemovePublications(ctx context.Context) error {
 	_, err := storage.publications.DeleteMany(ctx, bson.D{})
-	return err
+	if err != nil {
+		return err
+	}
+
+	// news are kept, feeds of their users become empty
+	users, err := storage.newsUsers(ctx, bson.D{})
+	if err != nil {
+		return err
+	}
+
+	return storage.feedsChanged(ctx, users...)
 }
 
 func (storage *MongoNewsStorage) RemoveUserSources(ctx context.Context, user string) (err error) {
@@ -170,6 +311,7 @@ func (storage *MongoNewsStorage) AddPublication(ctx context.Context, p *Publicat
 	defer cur.Close(ctx)
 
 	var news []interface{}
+	var users []string
 	for cur.Next(ctx) {
 		var result sourceStruct
 		err := cur.Decode(&result)
@@ -182,11 +324,16 @@ func (storage *MongoNewsStorage) AddPublication(ctx context.Context, p *Publicat
 			Source:        p.Author.Id,
 			User:          result.User,
 			Order:         p.CreatedOn.UnixMilli(),
+			Kind:          result.Kind,
 		})
+		users = append(users, result.User)
 	}
 
 	if len(news) > 0 {
 		_, _ = storage.news.InsertMany(ctx, news)
+		if err := storage.feedsChanged(ctx, users...); err != nil {
+			return err
+		}
 	}
 	if err := cur.Err(); err != nil {
 		return err
@@ -197,11 +344,13 @@ func (storage *MongoNewsStorage) AddPublication(ctx context.Context, p *Publicat
 
 func (storage *MongoNewsStorage) AddPublications(ctx context.Context, publications []Publication) error {
 	ps := make([]any, 0, len(publications))
+	oIds := make([]primitive.ObjectID, 0, len(publications))
 	for _, p := range publications {
 		oId, err := primitive.ObjectIDFromHex(p.Id)
 		if err != nil {
 			return err
 		}
+		oIds = append(oIds, oId)
 
 		ps = append(ps, publicationStruct{
 			Id:        oId,
@@ -253,77 +402,284 @@ func (storage *MongoNewsStorage) AddPublications(ctx context.Context, publicatio
 	//	cur.Close(ctx)
 	//}
 
-	return err
+	if err != nil {
+		return err
+	}
+
+	// news which are kept while publications are replaced appear in feeds again
+	users, err := storage.newsUsers(ctx, bson.D{{"publicationId", bson.D{{"$in", oIds}}}})
+	if err != nil {
+		return err
+	}
+
+	return storage.feedsChanged(ctx, users...)
 }
 
 func (storage *MongoNewsStorage) UpdatePublication(ctx context.Context, publication *Publication) error {
-	f := bson.D{{"_id", publication.Id}}
+	oId, err := primitive.ObjectIDFromHex(publication.Id)
+	if err != nil {
+		return err
+	}
+
+	f := bson.D{{"_id", oId}}
 	d := bson.D{
 		{"$set", bson.D{{"content", publication.Content}}},
 	}
 
-	_, err := storage.publications.UpdateOne(ctx, f, d)
-
+	_, err = storage.publications.UpdateOne(ctx, f, d)
 	if err != nil {
 		return err
 	}
 
-	f = bson.D{{"publicationId", publication.Id}}
+	f = bson.D{{"publicationId", oId}}
 	_, err = storage.news.UpdateMany(ctx, f, d)
+	if err != nil {
+		return err
+	}
 
-	return err
+	users, err := storage.newsUsers(ctx, f)
+	if err != nil {
+		return err
+	}
+
+	return storage.feedsChanged(ctx, users...)
 }
 
 func (storage *MongoNewsStorage) RemovePublication(ctx context.Context, publication *Publication) error {
-	_, err := storage.publications.DeleteOne(ctx, bson.D{{"_id", publication.Id}})
+	oId, err := primitive.ObjectIDFromHex(publication.Id)
 	if err != nil {
 		return err
 	}
 
-	_, err = storage.news.DeleteMany(ctx, bson.D{{"publicationId", publication.Id}})
+	_, err = storage.publications.DeleteOne(ctx, bson.D{{"_id", oId}})
+	if err != nil {
+		return err
+	}
+
+	users, err := storage.newsUsers(ctx, bson.D{{"publicationId", oId}})
+	if err != nil {
+		return err
+	}
+
+	_, err = storage.news.DeleteMany(ctx, bson.D{{"publicationId", oId}})
+	if changedErr := storage.feedsChanged(ctx, users...); err == nil {
+		err = changedErr
+	}
 
 	return err
 }
 
-func (storage *MongoNewsStorage) FindNews(ctx context.Context, user string, cursor string, take int) ([]Publication, error) {
-	pIds, err := storage.findNews(ctx, user, cursor, take)
+func (storage *MongoNewsStorage) FindNews(ctx context.Context, user string, cursor string, take int, fields ...string) ([]Publication, error) {
+	projection, err := publicationProjection(fields)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(pIds) == 0 {
-		return make([]Publication, 0), nil
+	// cached first page is served while version of the feed is the same
+	if cursor == "" && projection == nil && storage.cache != nil {
+		version, err := storage.FindFeedVersion(ctx, user)
+		if err != nil {
+			return nil, err
+		}
+
+		return storage.cache.firstPage(ctx, user, version.Version, take, func(ctx context.Context) ([]Publication, error) {
+			return storage.aggregateNews(ctx, user, cursor, false, take, nil)
+		})
+	}
+
+	return storage.aggregateNews(ctx, user, cursor, false, take, projection)
+}
+
+// FindNewsBefore returns page of news preceding cursor
+func (storage *MongoNewsStorage) FindNewsBefore(ctx context.Context, user string, cursor string, take int, fields ...string) ([]Publication, error) {
+	projection, err := publicationProjection(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	return storage.aggregateNews(ctx, user, cursor, true, take, projection)
+}
+
+// FindNewsBatch fetches first page of news for each user, publications shared between feeds are fetched once,
+// at most concurrency feeds are read at once
+func (storage *MongoNewsStorage) FindNewsBatch(ctx context.Context, users []string, take int, concurrency int) (map[string][]Publication, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	feeds := make(map[string][]newsStruct, len(users))
+	mu := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+	sem := make(chan struct{}, concurrency)
+	var firstErr error
+
+	for _, user := range users {
+		user := user
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			news, err := storage.findNews(ctx, user, "", false, take)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				return
+			}
+			feeds[user] = news
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	seen := make(map[primitive.ObjectID]bool)
+	var pIds []primitive.ObjectID
+	for _, news := range feeds {
+		for _, n := range news {
+			if !seen[n.PublicationId] {
+				seen[n.PublicationId] = true
+				pIds = append(pIds, n.PublicationId)
+			}
+		}
+	}
+
+	ps, err := storage.loadPublications(ctx, pIds, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[string][]Publication, len(feeds))
+	for user, news := range feeds {
+		result[user] = withProvenance(inNewsOrder(ps, news), news)
+	}
+
+	return result, nil
+}
+
+// ExplainNews collects facts about how publication reached or didn't reach user's feed
+func (storage *MongoNewsStorage) ExplainNews(ctx context.Context, user string, publicationId string) (*Explanation, error) {
+	explanation := &Explanation{
+		User:          user,
+		PublicationId: publicationId,
+	}
+
+	p, err := storage.FindPublication(ctx, publicationId)
+	if err == ErrPublicationNotFound {
+		explanation.Verdict = "Publication is unknown to news feed"
+		return explanation, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	explanation.Publication = p
+
+	oId, _ := primitive.ObjectIDFromHex(p.Id)
+
+	var n newsStruct
+	err = storage.news.FindOne(ctx, bson.D{{"user", user}, {"publicationId", oId}}).Decode(&n)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	if err == nil {
+		entry := n.toNewsEntry()
+		explanation.News = &entry
+	}
+
+	if p.Author != nil {
+		var source sourceStruct
+		err = storage.sources.FindOne(ctx, bson.D{{"user", user}, {"source", p.Author.Id}}).Decode(&source)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return nil, err
+		}
+		if err == nil {
+			explanation.Source = &Provenance{Reason: source.Kind, Source: source.Source}
+		}
+	}
+
+	switch {
+	case explanation.News != nil:
+		explanation.InFeed = true
+		explanation.Verdict = fmt.Sprintf("Publication is delivered to the feed from source %s", explanation.News.Source)
+	case p.Author == nil:
+		explanation.Verdict = "Publication has no author, so it can't be delivered to any feed"
+	case explanation.Source == nil:
+		explanation.Verdict = fmt.Sprintf("Author %s is not a source of the user", p.Author.Id)
+	default:
+		explanation.Verdict = fmt.Sprintf("Author %s is a source of the user, but publication was not delivered to the feed", p.Author.Id)
+	}
+
+	return explanation, nil
+}
+
+func (storage *MongoNewsStorage) FindPublications(ctx context.Context, ids []string) ([]Publication, error) {
+	pIds := make([]primitive.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		if oId, err := primitive.ObjectIDFromHex(id); err == nil {
+			pIds = append(pIds, oId)
+		}
 	}
 
-	cur, err := storage.publications.Find(ctx,
-		bson.D{{"_id", bson.D{{"$in", pIds}}}},
-		options.Find().SetSort(bson.D{{"createdOn", -1}}))
+	return storage.loadPublications(ctx, pIds, nil)
+}
+
+func (storage *MongoNewsStorage) FindUserSources(ctx context.Context, user string, skip int, take int) ([]Source, error) {
+	cur, err := storage.sources.Find(ctx,
+		bson.D{{"user", user}},
+		options.Find().
+			SetSort(bson.D{{"source", 1}}).
+			SetSkip(int64(skip)).
+			SetLimit(int64(take)))
 	if err != nil {
 		return nil, err
 	}
 	defer cur.Close(ctx)
-	var publications []Publication
+
+	sources := make([]Source, 0, take)
 	for cur.Next(ctx) {
-		var result publicationStruct
+		var result sourceStruct
 		err := cur.Decode(&result)
 		if err != nil {
 			return nil, err
 		}
 
-		publications = append(publications, Publication{
-			Id:        result.Id.Hex(),
-			Content:   result.Content,
-			Author:    result.Author,
-			CreatedOn: time.UnixMilli(result.CreatedOn).In(time.UTC),
-			UpdatedOn: time.UnixMilli(result.UpdatedOn).In(time.UTC),
-			Media:     result.Media,
-		})
+		sources = append(sources, Source{Id: result.Source, Kind: result.Kind})
 	}
 	if err := cur.Err(); err != nil {
 		return nil, err
 	}
 
-	return publications, nil
+	return sources, nil
+}
+
+func (storage *MongoNewsStorage) FindPublication(ctx context.Context, id string) (*Publication, error) {
+	oId, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrPublicationNotFound
+	}
+
+	var result publicationStruct
+	err = storage.publications.FindOne(ctx, bson.D{{"_id", oId}}).Decode(&result)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrPublicationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	p := result.toPublication()
+	return &p, nil
 }
 
 func (storage *MongoNewsStorage) findPublications(ctx context.Context, author string) ([]publicationStruct, error) {
@@ -348,23 +704,183 @@ func (storage *MongoNewsStorage) findPublications(ctx context.Context, author st
 	return publications, nil
 }
 
-func (storage *MongoNewsStorage) findNews(ctx context.Context, user string, cursor string, take int) ([]primitive.ObjectID, error) {
+func (storage *MongoNewsStorage) loadPublications(ctx context.Context, pIds []primitive.ObjectID, projection bson.D) ([]Publication, error) {
+	if len(pIds) == 0 {
+		return make([]Publication, 0), nil
+	}
+
+	opts := options.Find().SetSort(bson.D{{"createdOn", -1}})
+	if projection != nil {
+		opts.SetProjection(projection)
+	}
+
+	cur, err := storage.publications.Find(ctx, bson.D{{"_id", bson.D{{"$in", pIds}}}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	publications := make([]Publication, 0, len(pIds))
+	for cur.Next(ctx) {
+		var result publicationStruct
+		err := cur.Decode(&result)
+		if err != nil {
+			return nil, err
+		}
+
+		publications = append(publications, result.toPublication())
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+
+	return publications, nil
+}
+
+// publicationFields maps json fields of publication onto stored ones,
+// provenance is taken from news so it needs nothing from publication document
+var publicationFields = map[string]string{
+	"id":         "_id",
+	"content":    "content",
+	"author":     "author",
+	"createdOn":  "createdOn",
+	"updatedOn":  "updatedOn",
+	"media":      "media",
+	"provenance": "",
+}
+
+// publicationProjection returns nil when all fields requested
+func publicationProjection(fields []string) (bson.D, error) {
+	if len(fields) == 0 {
+		return nil, nil
+	}
+
+	projection := bson.D{{"_id", 1}}
+	for _, f := range fields {
+		stored, ok := publicationFields[f]
+		if !ok {
+			return nil, errors.Wrap(ErrUnknownField, f)
+		}
+		if stored != "" && stored != "_id" {
+			projection = append(projection, bson.E{stored, 1})
+		}
+	}
+
+	return projection, nil
+}
+
+// aggregateNews reads page of news joined with publications in a single round trip,
+// publications keep order of news and news without publication are skipped
+func (storage *MongoNewsStorage) aggregateNews(ctx context.Context, user string, cursor string, backward bool, take int, projection bson.D) ([]Publication, error) {
+	filter, order, err := newsFilter(user, cursor, backward)
+	if err != nil {
+		return nil, err
+	}
+
+	lookup := bson.D{
+		{"from", storage.publications.Name()},
+		{"localField", "publicationId"},
+		{"foreignField", "_id"},
+		{"as", "publication"},
+	}
+	if projection != nil {
+		lookup = bson.D{
+			{"from", storage.publications.Name()},
+			{"let", bson.D{{"publicationId", "$publicationId"}}},
+			{"pipeline", bson.A{
+				bson.D{{"$match", bson.D{{"$expr", bson.D{{"$eq", bson.A{"$_id", "$$publicationId"}}}}}}},
+				bson.D{{"$project", projection}},
+			}},
+			{"as", "publication"},
+		}
+	}
+
+	cur, err := storage.news.Aggregate(ctx, mongo.Pipeline{
+		{{"$match", filter}},
+		{{"$sort", bson.D{{"order", order}}}},
+		{{"$limit", take}},
+		{{"$lookup", lookup}},
+		{{"$unwind", "$publication"}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	news := make([]newsStruct, 0, take)
+	publications := make([]Publication, 0, take)
+	for cur.Next(ctx) {
+		var result struct {
+			News        newsStruct        `bson:",inline"`
+			Publication publicationStruct `bson:"publication"`
+		}
+		if err := cur.Decode(&result); err != nil {
+			return nil, err
+		}
+
+		news = append(news, result.News)
+		publications = append(publications, result.Publication.toPublication())
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+
+	if backward {
+		for i, j := 0, len(news)-1; i < j; i, j = i+1, j-1 {
+			news[i], news[j] = news[j], news[i]
+			publications[i], publications[j] = publications[j], publications[i]
+		}
+	}
+
+	return withProvenance(publications, news), nil
+}
+
+// ValidateFeedQuery checks cursor and fields the way FindNews does, without reading the feed
+func ValidateFeedQuery(cursor string, fields ...string) error {
+	if _, _, err := newsFilter("", cursor, false); err != nil {
+		return err
+	}
+
+	_, err := publicationProjection(fields)
+
+	return err
+}
+
+func newsFilter(user string, cursor string, backward bool) (bson.M, int, error) {
 	filter := bson.M{"user": user}
-	if oId, err := primitive.ObjectIDFromHex(cursor); err == nil {
-		filter["publicationId"] = bson.M{"$lt": oId}
+	operator, order := "$lt", -1
+	if backward {
+		operator, order = "$gt", 1
+	}
+
+	if cursor != "" {
+		oId, err := primitive.ObjectIDFromHex(cursor)
+		if err != nil {
+			return nil, 0, ErrInvalidCursor
+		}
+		filter["publicationId"] = bson.M{operator: oId}
+	}
+
+	return filter, order, nil
+}
+
+// findNews returns page of news after cursor, or before it when backward is set
+func (storage *MongoNewsStorage) findNews(ctx context.Context, user string, cursor string, backward bool, take int) ([]newsStruct, error) {
+	filter, order, err := newsFilter(user, cursor, backward)
+	if err != nil {
+		return nil, err
 	}
 
 	cur, err := storage.news.Find(ctx,
 		filter,
 		options.Find().
-			SetSort(bson.D{{"order", -1}}).
+			SetSort(bson.D{{"order", order}}).
 			SetLimit(int64(take)))
 
 	if err != nil {
 		return nil, err
 	}
 	defer cur.Close(ctx)
-	var news []primitive.ObjectID
+	var news []newsStruct
 	for cur.Next(ctx) {
 		var result newsStruct
 		err := cur.Decode(&result)
@@ -372,12 +888,18 @@ func (storage *MongoNewsStorage) findNews(ctx context.Context, user string, curs
 			return nil, err
 		}
 
-		news = append(news, result.PublicationId)
+		news = append(news, result)
 	}
 	if err := cur.Err(); err != nil {
 		return nil, err
 	}
 
+	if backward {
+		for i, j := 0, len(news)-1; i < j; i, j = i+1, j-1 {
+			news[i], news[j] = news[j], news[i]
+		}
+	}
+
 	return news, nil
 }
 
@@ -390,14 +912,78 @@ type publicationStruct struct {
 	Media     []*Media           `bson:"media"`
 }
 
+func (p *publicationStruct) toPublication() Publication {
+	return Publication{
+		Id:        p.Id.Hex(),
+		Content:   p.Content,
+		Author:    p.Author,
+		CreatedOn: time.UnixMilli(p.CreatedOn).In(time.UTC),
+		UpdatedOn: time.UnixMilli(p.UpdatedOn).In(time.UTC),
+		Media:     p.Media,
+	}
+}
+
 type newsStruct struct {
 	PublicationId primitive.ObjectID `bson:"publicationId"`
 	Source        string             `bson:"source"`
 	User          string             `bson:"user"`
 	Order         int64              `bson:"order"`
+	Kind          SourceKind         `bson:"kind,omitempty"`
+}
+
+func (n *newsStruct) toNewsEntry() NewsEntry {
+	return NewsEntry{
+		PublicationId: n.PublicationId.Hex(),
+		Source:        n.Source,
+		Kind:          n.Kind,
+		Order:         time.UnixMilli(n.Order).In(time.UTC),
+	}
+}
+
+func publicationIds(news []newsStruct) []primitive.ObjectID {
+	pIds := make([]primitive.ObjectID, 0, len(news))
+	for _, n := range news {
+		pIds = append(pIds, n.PublicationId)
+	}
+
+	return pIds
+}
+
+// inNewsOrder arranges publications in order of news, publications missing in ps are skipped
+func inNewsOrder(ps []Publication, news []newsStruct) []Publication {
+	byId := make(map[string]Publication, len(ps))
+	for _, p := range ps {
+		byId[p.Id] = p
+	}
+
+	ordered := make([]Publication, 0, len(news))
+	for _, n := range news {
+		if p, ok := byId[n.PublicationId.Hex()]; ok {
+			ordered = append(ordered, p)
+		}
+	}
+
+	return ordered
+}
+
+// withProvenance tells for each publication which source delivered it to the feed
+func withProvenance(ps []Publication, news []newsStruct) []Publication {
+	byPublication := make(map[string]*newsStruct, len(news))
+	for i := range news {
+		byPublication[news[i].PublicationId.Hex()] = &news[i]
+	}
+
+	for i := range ps {
+		if n, ok := byPublication[ps[i].Id]; ok {
+			ps[i].Provenance = &Provenance{Reason: n.Kind, Source: n.Source}
+		}
+	}
+
+	return ps
 }
 
 type sourceStruct struct {
-	User   string `bson:"user"`
-	Source string `bson:"source"`
+	User   string     `bson:"user"`
+	Source string     `bson:"source"`
+	Kind   SourceKind `bson:"kind,omitempty"`
 }
@@ -0,0 +1,181 @@
+package news
+
+import (
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"os"
+	"testing"
+	"time"
+)
+
+// newTestStorage connects to database of TEST_MONGO_CONNECTION, tests are skipped without it
+func newTestStorage(tb testing.TB) *MongoNewsStorage {
+	tb.Helper()
+
+	connection := os.Getenv("TEST_MONGO_CONNECTION")
+	if connection == "" {
+		tb.Skip("TEST_MONGO_CONNECTION is not set")
+	}
+
+	return NewMongoNewsStorage(connection)
+}
+
+// newTestFeed subscribes new user to new author and removes both when test is finished
+func newTestFeed(tb testing.TB, storage *MongoNewsStorage) (string, string) {
+	tb.Helper()
+
+	ctx := context.Background()
+	user, author := primitive.NewObjectID().Hex(), primitive.NewObjectID().Hex()
+	if err := storage.AddUserSource(ctx, user, author, FriendSource); err != nil {
+		tb.Fatal(err)
+	}
+
+	tb.Cleanup(func() {
+		_ = storage.RemoveNews(ctx, user)
+		_ = storage.RemoveUserSources(ctx, user)
+	})
+
+	return user, author
+}
+
+func newTestPublication(author string, createdOn time.Time) *Publication {
+	return &Publication{
+		Id:        primitive.NewObjectID().Hex(),
+		Content:   "original",
+		Author:    &PublicationAuthor{Id: author, FullName: "Author"},
+		CreatedOn: createdOn,
+		UpdatedOn: createdOn,
+	}
+}
+
+// Publications are stored with ObjectID keys, filters by string id used to match nothing
+func TestUpdatePublication_MatchesObjectId(t *testing.T) {
+	storage := newTestStorage(t)
+	ctx := context.Background()
+	user, author := newTestFeed(t, storage)
+
+	p := newTestPublication(author, time.Now())
+	if err := storage.AddPublication(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = storage.RemovePublication(ctx, p) }()
+
+	p.Content = "updated"
+	if err := storage.UpdatePublication(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := storage.FindPublication(ctx, p.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Content != "updated" {
+		t.Errorf("publication content is %q, expected %q", stored.Content, "updated")
+	}
+
+	ps, err := storage.FindNews(ctx, user, "", 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) != 1 || ps[0].Content != "updated" {
+		t.Errorf("feed is %+v, expected updated publication", ps)
+	}
+}
+
+func TestRemovePublication_MatchesObjectId(t *testing.T) {
+	storage := newTestStorage(t)
+	ctx := context.Background()
+	user, author := newTestFeed(t, storage)
+
+	p := newTestPublication(author, time.Now())
+	if err := storage.AddPublication(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := storage.RemovePublication(ctx, p); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := storage.FindPublication(ctx, p.Id); err != ErrPublicationNotFound {
+		t.Errorf("publication lookup returned %v, expected %v", err, ErrPublicationNotFound)
+	}
+
+	ps, err := storage.FindNews(ctx, user, "", 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) != 0 {
+		t.Errorf("feed has %d publications, expected none", len(ps))
+	}
+}
+
+// newBenchmarkFeed fills feed of new user with publications of a single author
+func newBenchmarkFeed(b *testing.B, storage *MongoNewsStorage, publications int) string {
+	b.Helper()
+
+	ctx := context.Background()
+	user, author := primitive.NewObjectID().Hex(), primitive.NewObjectID().Hex()
+
+	ps := make([]Publication, 0, publications)
+	createdOn := time.Now().Add(-time.Duration(publications) * time.Minute)
+	for i := 0; i < publications; i++ {
+		ps = append(ps, *newTestPublication(author, createdOn.Add(time.Duration(i)*time.Minute)))
+	}
+	if err := storage.AddPublications(ctx, ps); err != nil {
+		b.Fatal(err)
+	}
+	if err := storage.AddUserSource(ctx, user, author, FriendSource); err != nil {
+		b.Fatal(err)
+	}
+
+	b.Cleanup(func() {
+		_ = storage.RemoveNews(ctx, user)
+		_ = storage.RemoveUserSources(ctx, user)
+		_, _ = storage.publications.DeleteMany(ctx, bson.D{{"author._id", author}})
+	})
+
+	return user
+}
+
+// BenchmarkFindNews_Aggregate reads page with news joined to publications by $lookup
+func BenchmarkFindNews_Aggregate(b *testing.B) {
+	storage := newTestStorage(b)
+	user := newBenchmarkFeed(b, storage, 500)
+	ctx := context.Background()
+
+	for _, take := range []int{20, 100} {
+		b.Run(fmt.Sprintf("take=%d", take), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := storage.aggregateNews(ctx, user, "", false, take, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkFindNews_TwoQueries reads page of news and then its publications, as FindNews did before aggregation
+func BenchmarkFindNews_TwoQueries(b *testing.B) {
+	storage := newTestStorage(b)
+	user := newBenchmarkFeed(b, storage, 500)
+	ctx := context.Background()
+
+	for _, take := range []int{20, 100} {
+		b.Run(fmt.Sprintf("take=%d", take), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				news, err := storage.findNews(ctx, user, "", false, take)
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				ps, err := storage.loadPublications(ctx, publicationIds(news), nil)
+				if err != nil {
+					b.Fatal(err)
+				}
+				_ = withProvenance(inNewsOrder(ps, news), news)
+			}
+		})
+	}
+}
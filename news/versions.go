@@ -0,0 +1,72 @@
+package news
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+// FeedVersion is changed by every write to the feed of the user, zero value means feed was never written
+type FeedVersion struct {
+	Version   int64
+	UpdatedOn time.Time
+}
+
+type feedVersionStruct struct {
+	User      string `bson:"_id"`
+	Version   int64  `bson:"version"`
+	UpdatedOn int64  `bson:"updatedOn"`
+}
+
+func (storage *MongoNewsStorage) FindFeedVersion(ctx context.Context, user string) (FeedVersion, error) {
+	var result feedVersionStruct
+	err := storage.versions.FindOne(ctx, bson.D{{"_id", user}}).Decode(&result)
+	if err == mongo.ErrNoDocuments {
+		return FeedVersion{}, nil
+	}
+	if err != nil {
+		return FeedVersion{}, err
+	}
+
+	return FeedVersion{Version: result.Version, UpdatedOn: time.UnixMilli(result.UpdatedOn)}, nil
+}
+
+// feedsChanged bumps versions of feeds of the users and drops their cached pages
+func (storage *MongoNewsStorage) feedsChanged(ctx context.Context, users ...string) error {
+	storage.cache.invalidateUsers(users...)
+	if len(users) == 0 {
+		return nil
+	}
+
+	now := time.Now().UnixMilli()
+	models := make([]mongo.WriteModel, 0, len(users))
+	for _, user := range users {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.D{{"_id", user}}).
+			SetUpdate(bson.D{{"$inc", bson.D{{"version", 1}}}, {"$max", bson.D{{"updatedOn", now}}}}).
+			SetUpsert(true))
+	}
+
+	_, err := storage.versions.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+
+	return err
+}
+
+// newsUsers returns users whose feeds have news matching the filter
+func (storage *MongoNewsStorage) newsUsers(ctx context.Context, filter bson.D) ([]string, error) {
+	values, err := storage.news.Distinct(ctx, "user", filter)
+	if err != nil {
+		return nil, err
+	}
+
+	users := make([]string, 0, len(values))
+	for _, value := range values {
+		if user, ok := value.(string); ok {
+			users = append(users, user)
+		}
+	}
+
+	return users, nil
+}
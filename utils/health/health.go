@@ -0,0 +1,105 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ghosts-network/news-feed/utils/logger"
+	"github.com/pkg/errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Check returns error when dependency of the component is not usable
+type Check func(ctx context.Context) error
+
+// Checks collects checks of components running in the process, components register them when they start
+type Checks struct {
+	mu     sync.RWMutex
+	names  []string
+	checks map[string]Check
+}
+
+func NewChecks() *Checks {
+	return &Checks{checks: make(map[string]Check)}
+}
+
+// Register adds check, check registered with the same name is replaced
+func (c *Checks) Register(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.checks[name]; !ok {
+		c.names = append(c.names, name)
+	}
+	c.checks[name] = check
+}
+
+type report struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// Run runs every check and returns report of each of them, process is healthy when every check passed
+func (c *Checks) Run(ctx context.Context) (bool, map[string]string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	healthy := true
+	results := make(map[string]string, len(c.names))
+	for _, name := range c.names {
+		if err := c.checks[name](ctx); err != nil {
+			healthy = false
+			results[name] = err.Error()
+		} else {
+			results[name] = "ok"
+		}
+	}
+
+	return healthy, results
+}
+
+// ServeHTTP responds with 200 when process is healthy and with 503 otherwise
+func (c *Checks) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	healthy, results := c.Run(ctx)
+
+	status, body := http.StatusOK, report{Status: "healthy", Checks: results}
+	if !healthy {
+		status, body.Status = http.StatusServiceUnavailable, "unhealthy"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// ListenAndServe exposes checks on /health until ctx is done, it is used by processes without web server
+func ListenAndServe(ctx context.Context, address string, checks *Checks) {
+	mux := http.NewServeMux()
+	mux.Handle("/health", checks)
+
+	srv := &http.Server{Addr: address, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	errc := make(chan error, 1)
+	go func() {
+		logger.Info(fmt.Sprintf("Starting health server on %s", address), &map[string]any{})
+		errc <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		logger.Error(errors.Wrap(err, "Health server stopped"), &map[string]any{})
+		return
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_ = srv.Shutdown(shutdownCtx)
+}